@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -18,32 +19,263 @@ var DEFAULT_CONFIG_FS embed.FS
 
 const DEFAULT_CONFIG_FILE = "instx.yaml"
 
+// An entry of `updater.sources`
+type SourceConfig struct {
+	Type      string   `yaml:"type"`
+	Url       string   `yaml:"url"`
+	Path      string   `yaml:"path"`
+	Instances []string `yaml:"instances"`
+}
+
+// Weights for each metric in `updater.advanced.score_weights`
+type ScoreWeights struct {
+	Latency     float64 `yaml:"latency"`
+	TlsGrade    float64 `yaml:"tls_grade"`
+	CspGrade    float64 `yaml:"csp_grade"`
+	Uptime      float64 `yaml:"uptime"`
+	VersionAge  float64 `yaml:"version_age"`
+	Reliability float64 `yaml:"reliability"`
+	Feedback    float64 `yaml:"feedback"`
+}
+
+// `updater.advanced`, which profiles can override
+type AdvancedConfig struct {
+	InitialRespWeight         float64      `yaml:"initial_resp_weight"`
+	SearchRespWeight          float64      `yaml:"search_resp_weight"`
+	GoogleSearchRespWeight    float64      `yaml:"google_search_resp_weight"`
+	WikipediaSearchRespWeight float64      `yaml:"wikipedia_search_resp_weight"`
+	OutlierMethod             string       `yaml:"outlier_method"`
+	OutlierMultiplier         float64      `yaml:"outlier_multiplier"`
+	MadThreshold              float64      `yaml:"mad_threshold"`
+	IqrMultiplier             float64      `yaml:"iqr_multiplier"`
+	SearxSpaceWeight          float64      `yaml:"searx_space_weight"`
+	RttWeight                 float64      `yaml:"rtt_weight"`
+	PacketLossWeight          float64      `yaml:"packet_loss_weight"`
+	ProbeFailurePenalty       float64      `yaml:"probe_failure_penalty"`
+	Scorer                    string       `yaml:"scorer"`
+	ScoreWeights              ScoreWeights `yaml:"score_weights"`
+	UptimePeriod              string       `yaml:"uptime_period"`
+	TimingStatistics          struct {
+		Initial   string `yaml:"initial"`
+		Search    string `yaml:"search"`
+		Google    string `yaml:"google"`
+		Wikipedia string `yaml:"wikipedia"`
+	} `yaml:"timing_statistics"`
+}
+
+// `updater.criteria`, which profiles can override
+type CriteriaConfig struct {
+	MinimumCspGrade    string   `yaml:"minimum_csp_grade"`
+	MinimumTlsGrade    string   `yaml:"minimum_tls_grade"`
+	AllowedHttpGrades  []string `yaml:"allowed_http_grades,flow"`
+	AllowAnalytics     bool     `yaml:"allow_analytics"`
+	IsOnion            bool     `yaml:"is_onion"`
+	RequireDnssec      bool     `yaml:"require_dnssec"`
+	SearxngPreference  string   `yaml:"searxng_preference"`
+	AllowedCountries   []string `yaml:"allowed_countries,flow"`
+	DeniedCountries    []string `yaml:"denied_countries,flow"`
+	AllowedNetworks    []string `yaml:"allowed_networks,flow"`
+	DeniedNetworks     []string `yaml:"denied_networks,flow"`
+	RequireIpv6        bool     `yaml:"require_ipv6"`
+	MinimumSearxng     string   `yaml:"minimum_searxng_version"`
+	MinimumSearx       string   `yaml:"minimum_searx_version"`
+	MaxVersionAge      int64    `yaml:"max_version_age"`
+	RequireUpstream    bool     `yaml:"require_upstream"`
+	UpstreamGitUrls    []string `yaml:"upstream_git_urls,flow"`
+	MinimumUptimeDay   float64  `yaml:"minimum_uptime_day"`
+	MinimumUptimeWeek  float64  `yaml:"minimum_uptime_week"`
+	MinimumUptimeMonth float64  `yaml:"minimum_uptime_month"`
+}
+
+// An entry of `proxy.rewrite`. Which fields are used depends on the type.
+type RewriteConfig struct {
+	Type    string            `yaml:"type"`
+	Params  map[string]string `yaml:"params"`
+	From    string            `yaml:"from"`
+	To      string            `yaml:"to"`
+	Allowed []string          `yaml:"allowed,flow"`
+	Bangs   map[string]string `yaml:"bangs"`
+}
+
+// An entry of `proxy.language_routing.rules`. Canidates that match any of
+// these are preferred for searches in the rule's language.
+type LanguageRule struct {
+	Countries []string `yaml:"countries,flow"`
+	Tlds      []string `yaml:"tlds,flow"`
+	Engines   []string `yaml:"engines,flow"`
+}
+
+// `proxy.preferences`. These are sent along with every search, so unlike
+// `preferences_url` they work on any instance. Empty values are left to the
+// instance.
+type PreferencesConfig struct {
+	Language        string   `yaml:"language"`
+	SafeSearch      string   `yaml:"safesearch"`
+	Categories      []string `yaml:"categories,flow"`
+	Engines         []string `yaml:"engines,flow"`
+	DisabledEngines []string `yaml:"disabled_engines,flow"`
+	TimeRange       string   `yaml:"time_range"`
+	Theme           string   `yaml:"theme"`
+	Autocomplete    string   `yaml:"autocomplete"`
+	ImageProxy      *bool    `yaml:"image_proxy"`
+	ResultsOnNewTab *bool    `yaml:"results_on_new_tab"`
+}
+
+// An entry of `profiles`. Anything it leaves out is taken from the top level.
+type ProfileConfig struct {
+	PreferencesUrl string            `yaml:"preferences_url"`
+	Preferences    PreferencesConfig `yaml:"preferences"`
+	Advanced       AdvancedConfig    `yaml:"advanced"`
+	Criteria       CriteriaConfig    `yaml:"criteria"`
+}
+
 type Config struct {
 	DefaultInstance string `yaml:"default_instance"`
 	Proxy           struct {
-		Port           int    `yaml:"port"`
-		PreferencesUrl string `yaml:"preferences_url"`
+		Port           int               `yaml:"port"`
+		PreferencesUrl string            `yaml:"preferences_url"`
+		Preferences    PreferencesConfig `yaml:"preferences"`
+		Rewrite        []RewriteConfig   `yaml:"rewrite"`
+		Mode           string            `yaml:"mode"`
+		Interstitial   struct {
+			Enabled      bool  `yaml:"enabled"`
+			Timeout      int64 `yaml:"timeout"`
+			MaxCanidates int   `yaml:"max_canidates"`
+		} `yaml:"interstitial"`
+		ReverseProxy struct {
+			Timeout     int64 `yaml:"timeout"`
+			MaxAttempts int   `yaml:"max_attempts"`
+		} `yaml:"reverse_proxy"`
+		HealthCheck struct {
+			Enabled  bool  `yaml:"enabled"`
+			Interval int64 `yaml:"interval"`
+			Timeout  int64 `yaml:"timeout"`
+			Failures int   `yaml:"failures"`
+			CoolDown int64 `yaml:"cool_down"`
+		} `yaml:"health_check"`
+		Selection struct {
+			Strategy      string `yaml:"strategy"`
+			TopN          int    `yaml:"top_n"`
+			RotateMinutes int64  `yaml:"rotate_minutes"`
+			RotateQueries int    `yaml:"rotate_queries"`
+		} `yaml:"selection"`
+		Autocomplete struct {
+			Enabled     bool  `yaml:"enabled"`
+			Timeout     int64 `yaml:"timeout"`
+			MaxAttempts int   `yaml:"max_attempts"`
+			CacheSize   int   `yaml:"cache_size"`
+			CacheTtl    int64 `yaml:"cache_ttl"`
+		} `yaml:"autocomplete"`
+		Feedback struct {
+			Enabled bool   `yaml:"enabled"`
+			Keyword string `yaml:"keyword"`
+			Penalty int64  `yaml:"penalty"`
+		} `yaml:"feedback"`
+		LanguageRouting struct {
+			Enabled     bool                    `yaml:"enabled"`
+			DetectQuery bool                    `yaml:"detect_query"`
+			Rules       map[string]LanguageRule `yaml:"rules"`
+		} `yaml:"language_routing"`
+		Sticky struct {
+			Enabled    bool   `yaml:"enabled"`
+			IdentifyBy string `yaml:"identify_by"`
+			Period     int64  `yaml:"period"`
+		} `yaml:"sticky"`
 	} `yaml:"proxy"`
 	Updater struct {
-		UpdateInterval    int64    `yaml:"update_interval"`
-		InstanceBlacklist []string `yaml:"instance_blacklist"`
-		Advanced          struct {
-			InitialRespWeight         float64 `yaml:"initial_resp_weight"`
-			SearchRespWeight          float64 `yaml:"search_resp_weight"`
-			GoogleSearchRespWeight    float64 `yaml:"google_search_resp_weight"`
-			WikipediaSearchRespWeight float64 `yaml:"wikipedia_search_resp_weight"`
-			OutlierMultiplier         float64 `yaml:"outlier_multiplier"`
-		} `yaml:"advanced"`
-		Criteria struct {
-			MinimumCspGrade   string   `yaml:"minimum_csp_grade"`
-			MinimumTlsGrade   string   `yaml:"minimum_tls_grade"`
-			AllowedHttpGrades []string `yaml:"allowed_http_grades,flow"`
-			AllowAnalytics    bool     `yaml:"allow_analytics"`
-			IsOnion           bool     `yaml:"is_onion"`
-			RequireDnssec     bool     `yaml:"require_dnssec"`
-			SearxngPreference string   `yaml:"searxng_preference"`
-		} `yaml:"criteria"`
+		UpdateInterval    int64          `yaml:"update_interval"`
+		StateMaxAge       int64          `yaml:"state_max_age"`
+		Sources           []SourceConfig `yaml:"sources"`
+		InstanceBlacklist []string       `yaml:"instance_blacklist"`
+		Probe             struct {
+			Type     string `yaml:"type"`
+			Count    int    `yaml:"count"`
+			Interval int64  `yaml:"interval"`
+			Timeout  int64  `yaml:"timeout"`
+		} `yaml:"probe"`
+		History struct {
+			Enabled  bool  `yaml:"enabled"`
+			MaxAge   int64 `yaml:"max_age"`
+			HalfLife int64 `yaml:"half_life"`
+		} `yaml:"history"`
+		SearchProbe struct {
+			Enabled   bool   `yaml:"enabled"`
+			Query     string `yaml:"query"`
+			Timeout   int64  `yaml:"timeout"`
+			OnFailure string `yaml:"on_failure"`
+		} `yaml:"search_probe"`
+		Advanced AdvancedConfig `yaml:"advanced"`
+		Criteria CriteriaConfig `yaml:"criteria"`
 	} `yaml:"updater"`
+
+	// Filled in from `profiles` by parseProfiles()
+	Profiles map[string]ProfileConfig `yaml:"-"`
+}
+
+// The profile served at the root. Named profiles are under /<name>/.
+const DEFAULT_PROFILE = ""
+
+// Get profile $name, or false if it doesn't exist. DEFAULT_PROFILE is the
+// top level settings.
+func (c *Config) GetProfile(name string) (ProfileConfig, bool) {
+	if name == DEFAULT_PROFILE {
+		return ProfileConfig{
+			PreferencesUrl: c.Proxy.PreferencesUrl,
+			Preferences:    c.Proxy.Preferences,
+			Advanced:       c.Updater.Advanced,
+			Criteria:       c.Updater.Criteria,
+		}, true
+	}
+
+	profile, ok := c.Profiles[name]
+	return profile, ok
+}
+
+// Names of every profile, starting with DEFAULT_PROFILE
+func (c *Config) ProfileNames() []string {
+	names := []string{DEFAULT_PROFILE}
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// Decode `profiles` from $data. Each profile starts as a copy of the top level
+// settings so it only has to mention what it changes.
+func (c *Config) parseProfiles(data []byte) error {
+	var raw struct {
+		Profiles map[string]yaml.Node `yaml:"profiles"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	c.Profiles = make(map[string]ProfileConfig)
+	for name, node := range raw.Profiles {
+		profile, _ := c.GetProfile(DEFAULT_PROFILE)
+
+		// Slices and pointers would otherwise be shared with the top level,
+		// and yaml.v3 decodes into whatever a pointer already points at
+		profile.Criteria.AllowedHttpGrades = append([]string(nil), profile.Criteria.AllowedHttpGrades...)
+		profile.Preferences.ImageProxy = copyBool(profile.Preferences.ImageProxy)
+		profile.Preferences.ResultsOnNewTab = copyBool(profile.Preferences.ResultsOnNewTab)
+
+		if err := node.Decode(&profile); err != nil {
+			return err
+		}
+		c.Profiles[name] = profile
+	}
+
+	return nil
+}
+
+func copyBool(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+	ret := *b
+	return &ret
 }
 
 func createDefaultConfig(path string) error {
@@ -169,8 +401,25 @@ func ParseConfig() Config {
 		notFirstRun = true
 	}
 
+	// Start from the defaults so that keys added after the config file was
+	// created still have sensible values.
 	conf := Config{}
-	err := yaml.Unmarshal([]byte(getConfigData()), &conf)
+	defaultData, err := DEFAULT_CONFIG_FS.ReadFile(DEFAULT_CONFIG_FILE)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	err = yaml.Unmarshal(defaultData, &conf)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	userData := getConfigData()
+	err = yaml.Unmarshal(userData, &conf)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	err = conf.parseProfiles(userData)
 	if err != nil {
 		log.Fatalln(err.Error())
 	}
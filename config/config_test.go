@@ -0,0 +1,51 @@
+package config
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseProfilesCopiesPointers(t *testing.T) {
+	data := []byte(`
+proxy:
+  preferences:
+    image_proxy: true
+profiles:
+  a:
+    preferences:
+      image_proxy: true
+      results_on_new_tab: true
+  b:
+    preferences:
+      image_proxy: false
+      results_on_new_tab: false
+`)
+
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.parseProfiles(data); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, field string, got *bool, want bool) {
+		if got == nil || *got != want {
+			t.Errorf("profile \"%s\": %s = %v, want %t", name, field, got, want)
+		}
+	}
+
+	top, _ := c.GetProfile(DEFAULT_PROFILE)
+	a, _ := c.GetProfile("a")
+	b, _ := c.GetProfile("b")
+	check("", "image_proxy", top.Preferences.ImageProxy, true)
+	check("a", "image_proxy", a.Preferences.ImageProxy, true)
+	check("a", "results_on_new_tab", a.Preferences.ResultsOnNewTab, true)
+	check("b", "image_proxy", b.Preferences.ImageProxy, false)
+	check("b", "results_on_new_tab", b.Preferences.ResultsOnNewTab, false)
+
+	if top.Preferences.ResultsOnNewTab != nil {
+		t.Error("the top level results_on_new_tab should stay unset")
+	}
+}
@@ -35,6 +35,34 @@ func (e *ErrCouldNotBindPort) Error() string {
 		DEFAULT_CONFIG_FILE, e.given, e.key, e.err.Error())
 }
 
+var profileNameRegex = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+var searxngVersionRegex = regexp.MustCompile(`^\d{4}\.\d{1,2}\.\d{1,2}$`)
+var searxVersionRegex = regexp.MustCompile(`^\d+(\.\d+){0,2}$`)
+
+var languageRegex = regexp.MustCompile(`^[a-z]{2,3}$`)
+var countryRegex = regexp.MustCompile(`^[A-Za-z]{2}$`)
+
+// Profiles are served under /<name>/, so these would hide SearX's own pages
+// or InstX's
+var reservedProfileNames = map[string]bool{
+	"search":        true,
+	"autocompleter": true,
+	"preferences":   true,
+	"about":         true,
+	"stats":         true,
+	"static":        true,
+	"image_proxy":   true,
+	"config":        true,
+	"clear_cookies": true,
+	"info":          true,
+	"healthz":       true,
+	"_instx":        true,
+	"cmd":           true,
+	"ping":          true,
+	"getstarted":    true,
+}
+
 // Validate instx.yaml
 func (c *Config) validateConfig() []error {
 	errorArray := make([]error, 0, 64)
@@ -65,6 +93,247 @@ func (c *Config) validateConfig() []error {
 		})
 	}
 
+	errorArray = append(errorArray, c.Proxy.Preferences.validate("proxy.preferences")...)
+
+	for i, rule := range c.Proxy.Rewrite {
+		key := fmt.Sprintf("proxy.rewrite[%d]", i)
+
+		switch strings.ToLower(rule.Type) {
+		case "defaults":
+			if len(rule.Params) == 0 {
+				errorArray = append(errorArray, &ErrInvalidValue{
+					key:      key + ".params",
+					given:    fmt.Sprint(rule.Params),
+					accepted: "At least one parameter, e.g. {language: en}",
+				})
+			}
+		case "remap":
+			if !strings.HasPrefix(rule.From, "/") {
+				errorArray = append(errorArray, &ErrInvalidValue{
+					key:      key + ".from",
+					given:    rule.From,
+					accepted: "A path starting with \"/\"",
+				})
+			}
+			if !strings.HasPrefix(rule.To, "/") {
+				errorArray = append(errorArray, &ErrInvalidValue{
+					key:      key + ".to",
+					given:    rule.To,
+					accepted: "A path starting with \"/\"",
+				})
+			}
+		case "strip":
+			break
+		case "bangs":
+			if len(rule.Bangs) == 0 {
+				errorArray = append(errorArray, &ErrInvalidValue{
+					key:      key + ".bangs",
+					given:    fmt.Sprint(rule.Bangs),
+					accepted: "At least one bang, e.g. {img: images}",
+				})
+			}
+		default:
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      key + ".type",
+				given:    rule.Type,
+				accepted: "defaults, remap, strip, bangs. Check the README for more information.",
+			})
+		}
+	}
+
+	for language, rule := range c.Proxy.LanguageRouting.Rules {
+		if !languageRegex.MatchString(language) {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.language_routing.rules",
+				given:    language,
+				accepted: "Two or three letter language codes, e.g. de",
+			})
+		}
+		for i, country := range rule.Countries {
+			if !countryRegex.MatchString(country) {
+				errorArray = append(errorArray, &ErrInvalidValue{
+					key:      fmt.Sprintf("proxy.language_routing.rules.%s.countries[%d]", language, i),
+					given:    country,
+					accepted: "Two letter country codes, e.g. DE",
+				})
+			}
+		}
+	}
+
+	switch strings.ToLower(c.Proxy.Mode) {
+	case "redirect":
+		break
+	case "reverse_proxy":
+		if c.Proxy.ReverseProxy.Timeout <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.reverse_proxy.timeout",
+				given:    fmt.Sprint(c.Proxy.ReverseProxy.Timeout),
+				accepted: "Any number (in seconds) greater than 0.",
+			})
+		}
+		if c.Proxy.ReverseProxy.MaxAttempts <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.reverse_proxy.max_attempts",
+				given:    fmt.Sprint(c.Proxy.ReverseProxy.MaxAttempts),
+				accepted: "Any number greater than 0.",
+			})
+		}
+	default:
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      "proxy.mode",
+			given:    c.Proxy.Mode,
+			accepted: "redirect, reverse_proxy. Check the README for more information.",
+		})
+	}
+
+	if c.Proxy.Interstitial.Enabled {
+		if c.Proxy.Interstitial.Timeout <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.interstitial.timeout",
+				given:    fmt.Sprint(c.Proxy.Interstitial.Timeout),
+				accepted: "Any number (in seconds) greater than 0.",
+			})
+		}
+		if c.Proxy.Interstitial.MaxCanidates <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.interstitial.max_canidates",
+				given:    fmt.Sprint(c.Proxy.Interstitial.MaxCanidates),
+				accepted: "Any number greater than 0.",
+			})
+		}
+	}
+
+	if c.Proxy.HealthCheck.Enabled {
+		if c.Proxy.HealthCheck.Interval <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.health_check.interval",
+				given:    fmt.Sprint(c.Proxy.HealthCheck.Interval),
+				accepted: "Any number (in seconds) greater than 0.",
+			})
+		}
+		if c.Proxy.HealthCheck.Timeout <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.health_check.timeout",
+				given:    fmt.Sprint(c.Proxy.HealthCheck.Timeout),
+				accepted: "Any number (in seconds) greater than 0.",
+			})
+		}
+		if c.Proxy.HealthCheck.Failures <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.health_check.failures",
+				given:    fmt.Sprint(c.Proxy.HealthCheck.Failures),
+				accepted: "Any number greater than 0.",
+			})
+		}
+		if c.Proxy.HealthCheck.CoolDown < 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.health_check.cool_down",
+				given:    fmt.Sprint(c.Proxy.HealthCheck.CoolDown),
+				accepted: "Any number (in minutes) of at least 0.",
+			})
+		}
+	}
+
+	switch strings.ToLower(c.Proxy.Selection.Strategy) {
+	case "best", "round_robin", "weighted_random", "rotation":
+		break
+	default:
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      "proxy.selection.strategy",
+			given:    c.Proxy.Selection.Strategy,
+			accepted: "best, round_robin, weighted_random, rotation. Check the README for more information.",
+		})
+	}
+	if c.Proxy.Selection.TopN < 0 {
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      "proxy.selection.top_n",
+			given:    fmt.Sprint(c.Proxy.Selection.TopN),
+			accepted: "Any number of at least 0. 0 uses every canidate.",
+		})
+	}
+	if c.Proxy.Selection.RotateMinutes < 0 {
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      "proxy.selection.rotate_minutes",
+			given:    fmt.Sprint(c.Proxy.Selection.RotateMinutes),
+			accepted: "Any number (in minutes) of at least 0. 0 disables this limit.",
+		})
+	}
+	if c.Proxy.Selection.RotateQueries < 0 {
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      "proxy.selection.rotate_queries",
+			given:    fmt.Sprint(c.Proxy.Selection.RotateQueries),
+			accepted: "Any number of at least 0. 0 disables this limit.",
+		})
+	}
+
+	if c.Proxy.Autocomplete.Enabled {
+		if c.Proxy.Autocomplete.Timeout <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.autocomplete.timeout",
+				given:    fmt.Sprint(c.Proxy.Autocomplete.Timeout),
+				accepted: "Any number (in milliseconds) greater than 0.",
+			})
+		}
+		if c.Proxy.Autocomplete.MaxAttempts <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.autocomplete.max_attempts",
+				given:    fmt.Sprint(c.Proxy.Autocomplete.MaxAttempts),
+				accepted: "Any number greater than 0.",
+			})
+		}
+		if c.Proxy.Autocomplete.CacheSize < 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.autocomplete.cache_size",
+				given:    fmt.Sprint(c.Proxy.Autocomplete.CacheSize),
+				accepted: "Any number of at least 0. 0 disables the cache.",
+			})
+		}
+		if c.Proxy.Autocomplete.CacheSize > 0 && c.Proxy.Autocomplete.CacheTtl <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.autocomplete.cache_ttl",
+				given:    fmt.Sprint(c.Proxy.Autocomplete.CacheTtl),
+				accepted: "Any number (in minutes) greater than 0.",
+			})
+		}
+	}
+
+	if c.Proxy.Feedback.Enabled {
+		if len(c.Proxy.Feedback.Keyword) > 0 && strings.ContainsAny(c.Proxy.Feedback.Keyword, " \t") {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.feedback.keyword",
+				given:    c.Proxy.Feedback.Keyword,
+				accepted: "A single word, or nothing to disable the keyword.",
+			})
+		}
+		if c.Proxy.Feedback.Penalty <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.feedback.penalty",
+				given:    fmt.Sprint(c.Proxy.Feedback.Penalty),
+				accepted: "Any number (in minutes) greater than 0.",
+			})
+		}
+	}
+
+	if c.Proxy.Sticky.Enabled {
+		switch strings.ToLower(c.Proxy.Sticky.IdentifyBy) {
+		case "cookie", "address":
+			break
+		default:
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.sticky.identify_by",
+				given:    c.Proxy.Sticky.IdentifyBy,
+				accepted: "cookie, address. Check the README for more information.",
+			})
+		}
+		if c.Proxy.Sticky.Period <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "proxy.sticky.period",
+				given:    fmt.Sprint(c.Proxy.Sticky.Period),
+				accepted: "Any number (in minutes) greater than 0.",
+			})
+		}
+	}
+
 	minTime := int64(0)
 	maxTime := int64(^uint64(0)>>1) / int64(time.Minute)
 	if c.Updater.UpdateInterval < minTime || c.Updater.UpdateInterval > maxTime {
@@ -75,6 +344,186 @@ func (c *Config) validateConfig() []error {
 		})
 	}
 
+	if c.Updater.StateMaxAge < minTime || c.Updater.StateMaxAge > maxTime {
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      "updater.state_max_age",
+			given:    fmt.Sprint(c.Updater.StateMaxAge),
+			accepted: fmt.Sprintf("Any number (in minutes) from %d-%d. 0 disables restoring.", minTime, maxTime),
+		})
+	}
+
+	errorArray = append(errorArray, c.Updater.Advanced.validate("updater.advanced")...)
+	errorArray = append(errorArray, c.Updater.Criteria.validate("updater.criteria")...)
+
+	for name, profile := range c.Profiles {
+		prefix := "profiles." + name
+		if !profileNameRegex.MatchString(name) || reservedProfileNames[name] {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      prefix,
+				given:    name,
+				accepted: "Lowercase letters, digits, \"-\" and \"_\", except the names of SearX pages.",
+			})
+		}
+
+		errorArray = append(errorArray, profile.Preferences.validate(prefix+".preferences")...)
+		errorArray = append(errorArray, profile.Advanced.validate(prefix+".advanced")...)
+		errorArray = append(errorArray, profile.Criteria.validate(prefix+".criteria")...)
+
+		// Timings are measured once for every profile
+		if profile.Advanced.TimingStatistics != c.Updater.Advanced.TimingStatistics {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      prefix + ".advanced.timing_statistics",
+				given:    fmt.Sprint(profile.Advanced.TimingStatistics),
+				accepted: "The same as updater.advanced.timing_statistics.",
+			})
+		}
+	}
+
+	switch strings.ToLower(c.Updater.Probe.Type) {
+	case "icmp", "tcp", "tls":
+		break
+	default:
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      "updater.probe.type",
+			given:    c.Updater.Probe.Type,
+			accepted: "icmp, tcp, tls. Check the README for more information.",
+		})
+	}
+	if c.Updater.Probe.Count <= 0 {
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      "updater.probe.count",
+			given:    fmt.Sprint(c.Updater.Probe.Count),
+			accepted: "Any number greater than 0.",
+		})
+	}
+	if c.Updater.Probe.Interval < 0 {
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      "updater.probe.interval",
+			given:    fmt.Sprint(c.Updater.Probe.Interval),
+			accepted: "Any number (in milliseconds) greater than or equal to 0.",
+		})
+	}
+	if c.Updater.Probe.Timeout <= 0 {
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      "updater.probe.timeout",
+			given:    fmt.Sprint(c.Updater.Probe.Timeout),
+			accepted: "Any number (in milliseconds) greater than 0.",
+		})
+	}
+
+	if c.Updater.History.Enabled {
+		if c.Updater.History.MaxAge <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "updater.history.max_age",
+				given:    fmt.Sprint(c.Updater.History.MaxAge),
+				accepted: "Any number (in days) greater than 0.",
+			})
+		}
+		if c.Updater.History.HalfLife <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "updater.history.half_life",
+				given:    fmt.Sprint(c.Updater.History.HalfLife),
+				accepted: "Any number (in hours) greater than 0.",
+			})
+		}
+	}
+
+	if c.Updater.SearchProbe.Enabled {
+		if len(strings.TrimSpace(c.Updater.SearchProbe.Query)) == 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "updater.search_probe.query",
+				given:    c.Updater.SearchProbe.Query,
+				accepted: "Any non-empty search query.",
+			})
+		}
+		if c.Updater.SearchProbe.Timeout <= 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "updater.search_probe.timeout",
+				given:    fmt.Sprint(c.Updater.SearchProbe.Timeout),
+				accepted: "Any number (in seconds) greater than 0.",
+			})
+		}
+		switch strings.ToLower(c.Updater.SearchProbe.OnFailure) {
+		case "drop", "demote":
+			break
+		default:
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      "updater.search_probe.on_failure",
+				given:    c.Updater.SearchProbe.OnFailure,
+				accepted: "drop, demote. Check the README for more information.",
+			})
+		}
+	}
+
+	for i, source := range c.Updater.Sources {
+		key := fmt.Sprintf("updater.sources[%d]", i)
+
+		switch strings.ToLower(source.Type) {
+		case "remote":
+			if _, err := urllib.ParseRequestURI(source.Url); err != nil {
+				errorArray = append(errorArray, &ErrInvalidValue{
+					key:      key + ".url",
+					given:    source.Url,
+					accepted: "Any valid URL (accepted by net.url.Parse)",
+				})
+			}
+		case "file":
+			if len(source.Path) == 0 {
+				errorArray = append(errorArray, &ErrInvalidValue{
+					key:      key + ".path",
+					given:    source.Path,
+					accepted: "Path to a searx.space-compatible instances.json",
+				})
+			}
+		case "static":
+			for j, inst := range source.Instances {
+				url, err := urllib.Parse(inst)
+				if err != nil || len(url.Host) == 0 {
+					errorArray = append(errorArray, &ErrInvalidValue{
+						key:      fmt.Sprintf("%s.instances[%d]", key, j),
+						given:    inst,
+						accepted: "Any valid URL (net.url.Parse)",
+					})
+				}
+			}
+		default:
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      key + ".type",
+				given:    source.Type,
+				accepted: "remote, file, static. Check the README for more information.",
+			})
+		}
+	}
+
+	for i, inst := range c.Updater.InstanceBlacklist {
+		url, err := urllib.Parse(inst)
+		if err != nil || len(url.Host) == 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      fmt.Sprintf("updater.instance_blacklist[%d]", i),
+				given:    inst,
+				accepted: "Any valid URL (net.url.Parse)",
+			})
+		}
+	}
+
+	return errorArray
+}
+
+// Attempt to bind to port. Successful if return is nil.
+func tryBindToPort(port int) error {
+	conn, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	err = conn.Close()
+	return err
+}
+
+// Validate the advanced settings at $prefix, which are either
+// `updater.advanced` or a profile's
+func (a *AdvancedConfig) validate(prefix string) []error {
+	var errorArray []error
+
 	respWeightHelper := func(k string, v float64) {
 		if v <= 0 || v >= 2 {
 			errorArray = append(errorArray, &ErrInvalidValue{
@@ -86,17 +535,143 @@ func (c *Config) validateConfig() []error {
 	}
 
 	respWeightHelper(
-		"updater.advanced.initial_resp_weight",
-		c.Updater.Advanced.InitialRespWeight)
+		prefix+".initial_resp_weight",
+		a.InitialRespWeight)
 	respWeightHelper(
-		"updater.advanced.search_resp_weight",
-		c.Updater.Advanced.SearchRespWeight)
+		prefix+".search_resp_weight",
+		a.SearchRespWeight)
 	respWeightHelper(
-		"updater.advanced.google_search_resp_weight",
-		c.Updater.Advanced.GoogleSearchRespWeight)
+		prefix+".google_search_resp_weight",
+		a.GoogleSearchRespWeight)
 	respWeightHelper(
-		"updater.advanced.wikipedia_search_resp_weight",
-		c.Updater.Advanced.WikipediaSearchRespWeight)
+		prefix+".wikipedia_search_resp_weight",
+		a.WikipediaSearchRespWeight)
+
+	switch strings.ToLower(a.OutlierMethod) {
+	case "mean", "mad", "iqr":
+		break
+	default:
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      prefix + ".outlier_method",
+			given:    a.OutlierMethod,
+			accepted: "mean, mad, iqr. Check the README for more information.",
+		})
+	}
+	if a.MadThreshold <= 0 {
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      prefix + ".mad_threshold",
+			given:    fmt.Sprint(a.MadThreshold),
+			accepted: "Any number n: n > 0. Check the README for more information.",
+		})
+	}
+
+	nonNegativeHelper := func(k string, v float64) {
+		if v < 0 {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      k,
+				given:    fmt.Sprint(v),
+				accepted: "Any number n: n >= 0. Check the README for more information.",
+			})
+		}
+	}
+
+	nonNegativeHelper(
+		prefix+".iqr_multiplier",
+		a.IqrMultiplier)
+	nonNegativeHelper(
+		prefix+".searx_space_weight",
+		a.SearxSpaceWeight)
+	nonNegativeHelper(
+		prefix+".rtt_weight",
+		a.RttWeight)
+	nonNegativeHelper(
+		prefix+".packet_loss_weight",
+		a.PacketLossWeight)
+	nonNegativeHelper(
+		prefix+".probe_failure_penalty",
+		a.ProbeFailurePenalty)
+
+	switch strings.ToLower(a.Scorer) {
+	case "normalized", "response_time":
+		break
+	default:
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      prefix + ".scorer",
+			given:    a.Scorer,
+			accepted: "normalized, response_time. Check the README for more information.",
+		})
+	}
+
+	nonNegativeHelper(
+		prefix+".score_weights.latency",
+		a.ScoreWeights.Latency)
+	nonNegativeHelper(
+		prefix+".score_weights.tls_grade",
+		a.ScoreWeights.TlsGrade)
+	nonNegativeHelper(
+		prefix+".score_weights.csp_grade",
+		a.ScoreWeights.CspGrade)
+	nonNegativeHelper(
+		prefix+".score_weights.uptime",
+		a.ScoreWeights.Uptime)
+	nonNegativeHelper(
+		prefix+".score_weights.version_age",
+		a.ScoreWeights.VersionAge)
+	nonNegativeHelper(
+		prefix+".score_weights.reliability",
+		a.ScoreWeights.Reliability)
+	nonNegativeHelper(
+		prefix+".score_weights.feedback",
+		a.ScoreWeights.Feedback)
+
+	switch strings.ToLower(a.UptimePeriod) {
+	case "day", "week", "month":
+		break
+	default:
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      prefix + ".uptime_period",
+			given:    a.UptimePeriod,
+			accepted: "day, week, month",
+		})
+	}
+
+	// Checks whether stat is a statistic searx.space publishes (or that can
+	// be estimated from one)
+	isTimingStatistic := func(stat string) bool {
+		re := regexp.MustCompile(`^(?i:value|median|mean|stdev|p[1-9][0-9]?)$`)
+		return re.MatchString(stat)
+	}
+
+	timingStatHelper := func(k string, v string) {
+		if !isTimingStatistic(v) {
+			errorArray = append(errorArray, &ErrInvalidValue{
+				key:      k,
+				given:    v,
+				accepted: "value, median, mean, stdev, p1-p99. Check the README for more information.",
+			})
+		}
+	}
+
+	timingStatHelper(
+		prefix+".timing_statistics.initial",
+		a.TimingStatistics.Initial)
+	timingStatHelper(
+		prefix+".timing_statistics.search",
+		a.TimingStatistics.Search)
+	timingStatHelper(
+		prefix+".timing_statistics.google",
+		a.TimingStatistics.Google)
+	timingStatHelper(
+		prefix+".timing_statistics.wikipedia",
+		a.TimingStatistics.Wikipedia)
+
+	return errorArray
+}
+
+// Validate the criteria at $prefix, which are either `updater.criteria` or
+// a profile's
+func (cr *CriteriaConfig) validate(prefix string) []error {
+	var errorArray []error
 
 	// Checks whether grade is a valid letter grade.
 	// Valid: A+, A, A-, B+, B, B-, C+, C, C-, D+, D, D-, F
@@ -107,22 +682,22 @@ func (c *Config) validateConfig() []error {
 		return re.Match([]byte(grade))
 	}
 
-	if !isLetterGrade(c.Updater.Criteria.MinimumCspGrade) {
+	if !isLetterGrade(cr.MinimumCspGrade) {
 		errorArray = append(errorArray, &ErrInvalidValue{
-			key:      "updater.criteria.minimum_csp_grade",
-			given:    c.Updater.Criteria.MinimumCspGrade,
+			key:      prefix + ".minimum_csp_grade",
+			given:    cr.MinimumCspGrade,
 			accepted: "A+, A, A-, B+, B, B-, C+, C, C-, D+, D, D-, F",
 		})
 	}
-	if !isLetterGrade(c.Updater.Criteria.MinimumTlsGrade) {
+	if !isLetterGrade(cr.MinimumTlsGrade) {
 		errorArray = append(errorArray, &ErrInvalidValue{
-			key:      "updater.criteria.minimum_tls_grade",
-			given:    c.Updater.Criteria.MinimumTlsGrade,
+			key:      prefix + ".minimum_tls_grade",
+			given:    cr.MinimumTlsGrade,
 			accepted: "A+, A, A-, B+, B, B-, C+, C, C-, D+, D, D-, F",
 		})
 	}
 
-	for _, grade := range c.Updater.Criteria.AllowedHttpGrades {
+	for _, grade := range cr.AllowedHttpGrades {
 		switch strings.ToLower(grade) {
 		case "v":
 			continue
@@ -138,14 +713,14 @@ func (c *Config) validateConfig() []error {
 			continue
 		default:
 			errorArray = append(errorArray, &ErrInvalidValue{
-				key:      "updater.criteria.allowed_http_grades",
-				given:    strings.Join(c.Updater.Criteria.AllowedHttpGrades, ", "),
+				key:      prefix + ".allowed_http_grades",
+				given:    strings.Join(cr.AllowedHttpGrades, ", "),
 				accepted: "Check the README.",
 			})
 		}
 	}
 
-	switch strings.ToLower(c.Updater.Criteria.SearxngPreference) {
+	switch strings.ToLower(cr.SearxngPreference) {
 	case "required":
 		break
 	case "forbidden":
@@ -154,19 +729,66 @@ func (c *Config) validateConfig() []error {
 		break
 	default:
 		errorArray = append(errorArray, &ErrInvalidValue{
-			key:      "updater.criteria.searxng_preference",
-			given:    c.Updater.Criteria.SearxngPreference,
+			key:      prefix + ".searxng_preference",
+			given:    cr.SearxngPreference,
 			accepted: "required, forbidden, impartial. Check the README for more information.",
 		})
 	}
 
-	for i, inst := range c.Updater.InstanceBlacklist {
-		url, err := urllib.Parse(inst)
-		if err != nil || len(url.Host) == 0 {
+	for key, countries := range map[string][]string{
+		"allowed_countries": cr.AllowedCountries,
+		"denied_countries":  cr.DeniedCountries,
+	} {
+		for i, country := range countries {
+			if !countryRegex.MatchString(country) {
+				errorArray = append(errorArray, &ErrInvalidValue{
+					key:      fmt.Sprintf("%s.%s[%d]", prefix, key, i),
+					given:    country,
+					accepted: "Two letter country codes, e.g. DE",
+				})
+			}
+		}
+	}
+
+	if len(cr.MinimumSearxng) > 0 && !searxngVersionRegex.MatchString(cr.MinimumSearxng) {
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      prefix + ".minimum_searxng_version",
+			given:    cr.MinimumSearxng,
+			accepted: "A SearXNG version (YYYY.MM.DD), or empty for any version.",
+		})
+	}
+	if len(cr.MinimumSearx) > 0 && !searxVersionRegex.MatchString(cr.MinimumSearx) {
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      prefix + ".minimum_searx_version",
+			given:    cr.MinimumSearx,
+			accepted: "A SearX version (e.g. 1.1.0), or empty for any version.",
+		})
+	}
+	if cr.MaxVersionAge < 0 {
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      prefix + ".max_version_age",
+			given:    fmt.Sprint(cr.MaxVersionAge),
+			accepted: "Any number (in days) of at least 0. 0 disables this.",
+		})
+	}
+	if cr.RequireUpstream && len(cr.UpstreamGitUrls) == 0 {
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      prefix + ".upstream_git_urls",
+			given:    fmt.Sprint(cr.UpstreamGitUrls),
+			accepted: "At least one git URL when require_upstream is enabled.",
+		})
+	}
+
+	for key, minimum := range map[string]float64{
+		"minimum_uptime_day":   cr.MinimumUptimeDay,
+		"minimum_uptime_week":  cr.MinimumUptimeWeek,
+		"minimum_uptime_month": cr.MinimumUptimeMonth,
+	} {
+		if minimum < 0 || minimum > 100 {
 			errorArray = append(errorArray, &ErrInvalidValue{
-				key:      fmt.Sprintf("updater.instance_blacklist[%d]", i),
-				given:    inst,
-				accepted: "Any valid URL (net.url.Parse)",
+				key:      prefix + "." + key,
+				given:    fmt.Sprint(minimum),
+				accepted: "A percentage between 0 and 100. 0 disables this.",
 			})
 		}
 	}
@@ -174,12 +796,32 @@ func (c *Config) validateConfig() []error {
 	return errorArray
 }
 
-// Attempt to bind to port. Successful if return is nil.
-func tryBindToPort(port int) error {
-	conn, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		return err
+// Validate the preferences at $prefix, which are either `proxy.preferences`
+// or a profile's
+func (p *PreferencesConfig) validate(prefix string) []error {
+	var errorArray []error
+
+	switch strings.ToLower(p.SafeSearch) {
+	case "", "off", "moderate", "strict":
+		break
+	default:
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      prefix + ".safesearch",
+			given:    p.SafeSearch,
+			accepted: "off, moderate, strict, or empty to use the instance's setting.",
+		})
 	}
-	err = conn.Close()
-	return err
+
+	switch strings.ToLower(p.TimeRange) {
+	case "", "day", "week", "month", "year":
+		break
+	default:
+		errorArray = append(errorArray, &ErrInvalidValue{
+			key:      prefix + ".time_range",
+			given:    p.TimeRange,
+			accepted: "day, week, month, year, or empty for any time.",
+		})
+	}
+
+	return errorArray
 }
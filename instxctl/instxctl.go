@@ -11,6 +11,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"gitlab.com/Njinx/instx/config"
 	"gitlab.com/Njinx/instx/proxy"
@@ -90,14 +91,17 @@ func sendCommand(cmdReq *proxy.CommandRequest) (*proxy.CommandResponse, error) {
 	return &cmdResp, nil
 }
 
-func doStats() {
+func doStats(profile string) {
 	cmdResp, err := sendCommand(&proxy.CommandRequest{
 		Name: "stats",
-		Body: "",
+		Body: profile,
 	})
 	if err != nil {
 		log.Fatalf("Failed to send command: %s\n", err.Error())
 	}
+	if cmdResp.Err != "" {
+		log.Fatalf("Could not get stats: %s\n", cmdResp.Err)
+	}
 
 	canidates := updater.CanidatesMarshalable{}
 	if err := json.Unmarshal([]byte(cmdResp.Body), &canidates); err != nil {
@@ -113,19 +117,138 @@ func doStats() {
 		}
 	}
 
+	if !canidates.Updated.IsZero() {
+		fmt.Printf("Last updated: %s\n\n", canidates.Updated.Local().Format(time.RFC1123))
+	}
+	if canidates.Stale {
+		fmt.Println("Warning: instance sources couldn't be reached, so these rankings use cached data.")
+		fmt.Println()
+	}
+
 	for _, canidate := range canidates.List {
 		fmt.Printf("[%0.2f] %s", canidate.Score, canidate.Url)
 		if canidate.IsCurrent {
-			fmt.Println(" (In Use)")
-		} else {
-			fmt.Println()
+			fmt.Print(" (In Use)")
+		}
+		if !canidate.IsHealthy(time.Now()) {
+			fmt.Printf(" (Unhealthy until %s)", canidate.UnhealthyUntil.Local().Format(time.Kitchen))
+		}
+		if time.Now().Before(canidate.PenalizedUntil) {
+			fmt.Printf(" (Penalized until %s)", canidate.PenalizedUntil.Local().Format(time.Kitchen))
+		}
+		fmt.Println()
+
+		timingText := func(timing updater.Timing) string {
+			if timing.Ok {
+				return latText(timing.Value)
+			} else {
+				return "N/A"
+			}
 		}
 
+		for _, network := range canidate.Networks {
+			fmt.Printf("Network: %s\n", network.String())
+		}
+		fmt.Printf("Response time: %s\n", latText(canidate.ResponseTime))
 		fmt.Println("Latency:")
-		fmt.Printf("  - Initial:\t%s\n", latText(canidate.Timings.Initial))
-		fmt.Printf("  - Search:\t%s\n", latText(canidate.Timings.Search))
-		fmt.Printf("  - Google:\t%s\n", latText(canidate.Timings.Google))
-		fmt.Printf("  - Wikipedia:\t%0s\n", latText(canidate.Timings.Wikipedia))
+		fmt.Printf("  - Initial:\t%s\n", timingText(canidate.Timings.Initial))
+		fmt.Printf("  - Search:\t%s\n", timingText(canidate.Timings.Search))
+		fmt.Printf("  - Google:\t%s\n", timingText(canidate.Timings.Google))
+		fmt.Printf("  - Wikipedia:\t%0s\n", timingText(canidate.Timings.Wikipedia))
+
+		if result := canidate.Latency; result != nil {
+			if result.IsAlive {
+				fmt.Println("Measured:")
+				fmt.Printf("  - RTT:\t%s\n", latText(result.AvgLatency))
+				fmt.Printf("  - Loss:\t%0.0f%%\n", result.PacketLoss)
+			} else {
+				fmt.Println("Measured: Unreachable")
+			}
+		}
+
+		if probe := canidate.SearchProbe; probe != nil {
+			if probe.Ok {
+				fmt.Println("Search probe: OK")
+			} else {
+				fmt.Printf("Search probe: Failed (%s)\n", probe.Reason)
+			}
+			fmt.Printf("  - DNS:\t%s\n", latText(probe.Dns))
+			fmt.Printf("  - TLS:\t%s\n", latText(probe.Tls))
+			fmt.Printf("  - TTFB:\t%s\n", latText(probe.Ttfb))
+		}
+	}
+}
+
+func doRejected(profile string) {
+	cmdResp, err := sendCommand(&proxy.CommandRequest{
+		Name: "stats",
+		Body: profile,
+	})
+	if err != nil {
+		log.Fatalf("Failed to send command: %s\n", err.Error())
+	}
+	if cmdResp.Err != "" {
+		log.Fatalf("Could not get stats: %s\n", cmdResp.Err)
+	}
+
+	canidates := updater.CanidatesMarshalable{}
+	if err := json.Unmarshal([]byte(cmdResp.Body), &canidates); err != nil {
+		log.Fatalf("Could not unmarshal stats JSON: %s", err.Error())
+	}
+
+	if len(canidates.Rejected) == 0 {
+		fmt.Println("No instances were rejected.")
+	}
+	for _, rejection := range canidates.Rejected {
+		fmt.Printf("%s\n  - %s\n", rejection.Url, rejection.Reason)
+	}
+}
+
+// Number of samples shown per instance by `instxctl history`
+const HISTORY_SAMPLES_SHOWN = 10
+
+func doHistory(filter string) {
+	cmdResp, err := sendCommand(&proxy.CommandRequest{
+		Name: "history",
+		Body: filter,
+	})
+	if err != nil {
+		log.Fatalf("Failed to send command: %s\n", err.Error())
+	}
+	if cmdResp.Err != "" {
+		log.Fatalf("Could not get history: %s\n", cmdResp.Err)
+	}
+
+	var history []updater.InstanceHistory
+	if err := json.Unmarshal([]byte(cmdResp.Body), &history); err != nil {
+		log.Fatalf("Could not unmarshal history JSON: %s", err.Error())
+	}
+
+	if len(history) == 0 {
+		fmt.Println("No history yet.")
+	}
+	for _, instance := range history {
+		fmt.Printf("[%0.0f%%] %s\n", instance.Reliability*100, instance.Url)
+
+		samples := instance.Samples
+		if len(samples) > HISTORY_SAMPLES_SHOWN {
+			samples = samples[len(samples)-HISTORY_SAMPLES_SHOWN:]
+		}
+		for i := len(samples) - 1; i >= 0; i-- {
+			sample := samples[i]
+
+			status := "Down"
+			if sample.Alive {
+				status = "Up"
+			}
+			rank := "-"
+			if sample.Rank > 0 {
+				rank = fmt.Sprintf("#%d", sample.Rank)
+			}
+
+			fmt.Printf("  - %s\t%s\t%0.3fs\t%s\n",
+				sample.Time.Local().Format(time.RFC1123), status, sample.Latency, rank)
+		}
 	}
 }
 
@@ -148,7 +271,9 @@ func doUpdate() {
 
 func printUsage() {
 	fmt.Printf("Usage: %s COMMAND\n\n", os.Args[0])
-	fmt.Println("\ts, stats - Show statistics about all instances")
+	fmt.Println("\ts, stats [PROFILE] - Show statistics about all instances")
+	fmt.Println("\tr, rejected [PROFILE] - Show which instances were rejected and why")
+	fmt.Println("\th, history [URL] - Show how reliable each instance has been")
 	fmt.Println("\tu, update - Update the list of instances")
 	fmt.Println()
 }
@@ -161,9 +286,19 @@ func Run() {
 		os.Exit(1)
 	}
 
+	// Optional argument to the command
+	arg := ""
+	if len(os.Args) > 2 {
+		arg = os.Args[2]
+	}
+
 	switch os.Args[1] {
 	case "s", "stats":
-		doStats()
+		doStats(arg)
+	case "r", "rejected":
+		doRejected(arg)
+	case "h", "history":
+		doHistory(arg)
 	case "u", "update":
 		doUpdate()
 	default:
@@ -19,10 +19,10 @@ func main() {
 		instxctl.Run()
 	} else {
 		var updatedCanidatesMutex sync.Mutex
-		updatedCanidates := updater.NewCanidates()
+		updatedCanidates := updater.NewProfileCanidates()
 
-		go proxy.Run(&updatedCanidates, &updatedCanidatesMutex)
-		go updater.Run(&updatedCanidates, &updatedCanidatesMutex)
+		go proxy.Run(updatedCanidates, &updatedCanidatesMutex)
+		go updater.Run(updatedCanidates, &updatedCanidatesMutex)
 
 		select {}
 	}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	urllib "net/url"
+	"sync"
+	"time"
+
+	"gitlab.com/Njinx/instx/config"
+	"gitlab.com/Njinx/instx/updater"
+)
+
+// Don't read more than this much of a suggestions response
+const AUTOCOMPLETE_MAX_BODY = 64 << 10
+
+type ErrBadSuggestions struct {
+	Url string
+}
+
+func (err *ErrBadSuggestions) Error() string {
+	return fmt.Sprintf("Could not parse suggestions from \"%s\"", err.Url)
+}
+
+// Get the suggestions out of an autocompleter response. SearX answers in
+// the OpenSearch format, [query, [suggestions...]], unless it thinks the
+// request came from its own JavaScript, in which case it's just the list.
+func parseSuggestions(body []byte) ([]string, bool) {
+	var openSearch []json.RawMessage
+	if err := json.Unmarshal(body, &openSearch); err != nil {
+		return nil, false
+	}
+
+	var suggestions []string
+	if len(openSearch) >= 2 {
+		if err := json.Unmarshal(openSearch[1], &suggestions); err == nil {
+			return suggestions, true
+		}
+	}
+	if err := json.Unmarshal(body, &suggestions); err == nil {
+		return suggestions, true
+	}
+
+	return nil, false
+}
+
+// Recently fetched suggestions. The least recently used are dropped first.
+type suggestionCache struct {
+	size int
+	ttl  time.Duration
+
+	mutex   sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type suggestionCacheEntry struct {
+	query       string
+	suggestions []string
+	expires     time.Time
+}
+
+func newSuggestionCache(size int, ttl time.Duration) *suggestionCache {
+	return &suggestionCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *suggestionCache) get(query string, now time.Time) ([]string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.entries[query]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(suggestionCacheEntry)
+	if !now.Before(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, query)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.suggestions, true
+}
+
+func (c *suggestionCache) put(query string, suggestions []string, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := suggestionCacheEntry{query, suggestions, now.Add(c.ttl)}
+	if elem, ok := c.entries[query]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[query] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(suggestionCacheEntry).query)
+	}
+}
+
+// nil if caching is disabled
+var suggestionsCache *suggestionCache
+
+// Get suggestions for $req's query from the instance at $url. $p's
+// preferences go along so the instance uses its autocompleter and language.
+func fetchSuggestions(req *http.Request, p *profile, url string, timeout time.Duration) ([]string, error) {
+	base, err := urllib.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	query := req.URL.Query()
+	applyPreferences(p, query)
+	upstreamReq := req.Clone(req.Context())
+	upstreamReq.URL.RawQuery = query.Encode()
+
+	resp, err := fetchUpstream(upstreamReq, base, "/autocompleter", nil, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &updater.ErrUnexpectedStatus{Url: url, Status: resp.Status}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, AUTOCOMPLETE_MAX_BODY))
+	if err != nil {
+		return nil, err
+	}
+
+	results, ok := parseSuggestions(body)
+	if !ok {
+		return nil, &ErrBadSuggestions{url}
+	}
+	return results, nil
+}
+
+// Answer the browser's search suggestions using the client's instance,
+// falling back to the next canidates if it doesn't answer in time
+func autocompleteHandler(w http.ResponseWriter, req *http.Request) {
+	conf := config.ParseConfig().Proxy.Autocomplete
+	p := getProfile(req)
+	query := req.URL.Query().Get("q")
+	now := time.Now()
+
+	// Profiles send different preferences, e.g. the language, so they get
+	// different suggestions
+	cacheKey := p.Name + "\n" + query
+
+	results, ok := []string{}, false
+	if suggestionsCache != nil {
+		results, ok = suggestionsCache.get(cacheKey, now)
+	}
+
+	if !ok && len(query) > 0 {
+		url := getLastUrl(w, req)
+		if len(url) == 0 {
+			url = getClientUrl(w, req, req.URL.Query())
+		}
+
+		timeout := time.Duration(conf.Timeout) * time.Millisecond
+		for _, url := range getFallbackUrls(p, url, conf.MaxAttempts) {
+			fetched, err := fetchSuggestions(req, p, url, timeout)
+			if err != nil {
+				log.Printf("Could not get suggestions from \"%s\": %s\n", url, err.Error())
+				continue
+			}
+
+			results = fetched
+			if suggestionsCache != nil {
+				suggestionsCache.put(cacheKey, results, now)
+			}
+			break
+		}
+	}
+
+	if results == nil {
+		results = []string{}
+	}
+	resp, err := json.Marshal([]interface{}{query, results})
+	if err != nil {
+		log.Printf("Could not marshal suggestions: %s\n", err.Error())
+		resp = []byte("[]")
+	}
+
+	w.Header().Add("content-type", "application/x-suggestions+json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	urllib "net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSuggestions(t *testing.T) {
+	tests := map[string][]string{
+		`["linux", ["linux kernel", "linux mint"]]`: {"linux kernel", "linux mint"},
+		`["linux kernel", "linux mint"]`:            {"linux kernel", "linux mint"},
+		`["linux", []]`:                             {},
+	}
+
+	for body, want := range tests {
+		got, ok := parseSuggestions([]byte(body))
+		if !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("parseSuggestions(%s) = %v, %t, want %v", body, got, ok, want)
+		}
+	}
+
+	if _, ok := parseSuggestions([]byte(`<html>`)); ok {
+		t.Error("parseSuggestions should fail on HTML")
+	}
+}
+
+func TestSuggestionCache(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	cache := newSuggestionCache(2, time.Minute)
+
+	cache.put("a", []string{"a1"}, now)
+	cache.put("b", []string{"b1"}, now)
+	cache.get("a", now)
+	cache.put("c", []string{"c1"}, now)
+
+	if _, ok := cache.get("b", now); ok {
+		t.Error("the least recently used entry should have been dropped")
+	}
+	if got, ok := cache.get("a", now); !ok || got[0] != "a1" {
+		t.Errorf("get(a) = %v, %t", got, ok)
+	}
+	if _, ok := cache.get("c", now.Add(time.Minute)); ok {
+		t.Error("expired entries shouldn't be returned")
+	}
+}
+
+func TestFetchSuggestionsSendsPreferences(t *testing.T) {
+	var got urllib.Values
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `["linux", ["linux kernel"]]`)
+	}))
+	defer upstream.Close()
+
+	p := &profile{
+		Preferences: urllib.Values{
+			"autocomplete": {"duckduckgo"},
+			"language":     {"de"},
+		},
+	}
+	req := httptest.NewRequest("GET", "/autocompleter?q=linux", nil)
+
+	results, err := fetchSuggestions(req, p, upstream.URL, time.Second)
+	if err != nil {
+		t.Fatalf("fetchSuggestions() error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(results, []string{"linux kernel"}) {
+		t.Errorf("fetchSuggestions() = %v", results)
+	}
+	if got.Get("q") != "linux" || got.Get("autocomplete") != "duckduckgo" || got.Get("language") != "de" {
+		t.Errorf("instance got %s, want the query and the profile's preferences", got.Encode())
+	}
+}
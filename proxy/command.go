@@ -8,21 +8,22 @@ import (
 	"gitlab.com/Njinx/instx/updater"
 )
 
-func callCommand(key string) (string, error) {
-	var commands = map[string]func() (string, error){
-		"update": cmdUpdate,
-		"stats":  cmdStats,
+func callCommand(key string, body string) (string, error) {
+	var commands = map[string]func(string) (string, error){
+		"update":  cmdUpdate,
+		"stats":   cmdStats,
+		"history": cmdHistory,
 	}
 
 	fn, ok := commands[key]
 	if !ok {
-		return "", &ErrInvalidCommand{"update"}
+		return "", &ErrInvalidCommand{key}
 	} else {
-		return fn()
+		return fn(body)
 	}
 }
 
-func cmdUpdate() (string, error) {
+func cmdUpdate(_ string) (string, error) {
 	if err := updater.ForceUpdate(); errors.Is(err, &updater.ErrUpdateInProgress{}) {
 		return "Update already in progress", nil
 	} else {
@@ -30,16 +31,31 @@ func cmdUpdate() (string, error) {
 	}
 }
 
-func cmdStats() (string, error) {
+// $body is the profile to show. Empty for the default one.
+func cmdStats(body string) (string, error) {
 	updatedCanidatesMutex.Lock()
+	defer updatedCanidatesMutex.Unlock()
 
-	canidates := updater.NewCanidatesMarshalable(updatedCanidates)
+	p, ok := profiles[body]
+	if !ok {
+		return "", &ErrUnknownProfile{body}
+	}
+
+	canidates := updater.NewCanidatesMarshalable(p.Canidates)
 	json, err := json.Marshal(canidates)
 	if err != nil {
 		return "", err
 	}
 
-	updatedCanidatesMutex.Unlock()
+	return string(json), nil
+}
+
+// $body is an optional URL to filter by
+func cmdHistory(body string) (string, error) {
+	json, err := json.Marshal(updater.GetHistory(body))
+	if err != nil {
+		return "", err
+	}
 
 	return string(json), nil
 }
@@ -51,3 +67,11 @@ type ErrInvalidCommand struct {
 func (err *ErrInvalidCommand) Error() string {
 	return fmt.Sprintf("Invalid command: \"%s\"", err.Name)
 }
+
+type ErrUnknownProfile struct {
+	Name string
+}
+
+func (err *ErrUnknownProfile) Error() string {
+	return fmt.Sprintf("Unknown profile: \"%s\"", err.Name)
+}
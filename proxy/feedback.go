@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	urllib "net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/Njinx/instx/config"
+	"gitlab.com/Njinx/instx/updater"
+)
+
+// Penalizes the instance the client was last sent to
+const SKIP_PATH = "/_instx/skip"
+
+// How long a skipped instance is left out entirely. After that only its
+// score keeps it down for the rest of `proxy.feedback.penalty`.
+const SKIP_COOL_DOWN = 10 * time.Minute
+
+// Remembers which instance a client was last sent to when sticky sessions
+// are off. Each profile has its own.
+const LAST_URL_COOKIE = "instx_last"
+
+func getLastUrlCookieName(p *profile) string {
+	if p.Name == config.DEFAULT_PROFILE {
+		return LAST_URL_COOKIE
+	}
+	return LAST_URL_COOKIE + "_" + p.Name
+}
+
+// Remember that the client was sent to $url. Sticky clients are already
+// tracked by their assignment.
+func rememberLastUrl(w http.ResponseWriter, p *profile, url string) {
+	if config.ParseConfig().Proxy.Sticky.Enabled || len(url) == 0 {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     getLastUrlCookieName(p),
+		Value:    urllib.QueryEscape(url),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Get the instance the client making $req was last sent to. Empty if it
+// isn't known or is no longer a canidate.
+func getLastUrl(w http.ResponseWriter, req *http.Request) string {
+	p := getProfile(req)
+	sticky := config.ParseConfig().Proxy.Sticky.Enabled
+
+	var clientId string
+	if sticky {
+		clientId = getClientId(w, req)
+	}
+
+	updatedCanidatesMutex.Lock()
+	defer updatedCanidatesMutex.Unlock()
+
+	if sticky {
+		if assignment, ok := p.StickyAssignments[clientId]; ok {
+			return assignment.Url
+		}
+		return ""
+	}
+
+	cookie, err := req.Cookie(getLastUrlCookieName(p))
+	if err != nil {
+		return ""
+	}
+	last, err := urllib.QueryUnescape(cookie.Value)
+	if err != nil {
+		return ""
+	}
+
+	// Don't let a cookie penalize instances that were never used
+	var url string
+	p.Canidates.Iterate(func(canidate *updater.Canidate) bool {
+		if canidate.Url == last {
+			url = canidate.Url
+			return true
+		}
+		return false
+	})
+	return url
+}
+
+// Where to repeat the search in $query after skipping an instance
+func getSkipTarget(p *profile, query urllib.Values) string {
+	target := p.root() + "search"
+	if encoded := query.Encode(); len(encoded) > 0 {
+		target += "?" + encoded
+	}
+	return target
+}
+
+// Penalize the instance the client was last sent to for
+// `proxy.feedback.penalty` minutes and repeat the search in $query, with
+// all its parameters, on the next one
+func skipInstance(w http.ResponseWriter, req *http.Request, query urllib.Values) {
+	conf := config.ParseConfig().Proxy.Feedback
+	p := getProfile(req)
+
+	if url := getLastUrl(w, req); len(url) > 0 {
+		until := updater.PenalizeInstance(url, time.Duration(conf.Penalty)*time.Minute)
+		log.Printf("Penalizing \"%s\" until %s at a user's request\n", url, until.Format(time.RFC1123))
+
+		updatedCanidatesMutex.Lock()
+		updater.RescoreCanidates(p.Name, p.Canidates)
+
+		// The penalty might not be enough to move it down the ranking, so
+		// make sure the search isn't repeated on it. Sticky clients move on
+		// by themselves since their instance is no longer healthy. A longer
+		// cool-down from the health check is left alone.
+		now := time.Now()
+		if skipUntil := now.Add(SKIP_COOL_DOWN); skipUntil.After(unhealthyUntil[url]) {
+			unhealthyUntil[url] = skipUntil
+		}
+		if !config.ParseConfig().Proxy.Sticky.Enabled {
+			selectCanidate(p, now)
+		}
+		updatedCanidatesMutex.Unlock()
+	}
+
+	http.Redirect(w, req, getSkipTarget(p, query), http.StatusFound)
+}
+
+// /_instx/skip?q=...
+func skipHandler(w http.ResponseWriter, req *http.Request) {
+	skipInstance(w, req, req.URL.Query())
+}
+
+// Remove every occurrence of $keyword from $query. Returns false if there
+// weren't any.
+func stripKeyword(query string, keyword string) (string, bool) {
+	found := false
+	var kept []string
+	for _, word := range strings.Fields(query) {
+		if strings.EqualFold(word, keyword) {
+			found = true
+		} else {
+			kept = append(kept, word)
+		}
+	}
+
+	return strings.Join(kept, " "), found
+}
+
+// Get the search from $req's form if it was POSTed, otherwise from the URL.
+// The body is put back so the next handler can still read it.
+func readSearchQuery(req *http.Request) (urllib.Values, error) {
+	if !isFormSearch(req) {
+		return req.URL.Query(), nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, REVERSE_PROXY_MAX_REQUEST_BODY))
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	return urllib.ParseQuery(string(body))
+}
+
+// Treat searches containing `proxy.feedback.keyword` like a request to
+// /_instx/skip
+func withSkipKeyword(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		conf := config.ParseConfig().Proxy.Feedback
+		if conf.Enabled && len(conf.Keyword) > 0 {
+			query, err := readSearchQuery(req)
+			if err != nil {
+				log.Printf("Could not read search: %s\n", err.Error())
+				http.Error(w, "Could not read search", http.StatusBadRequest)
+				return
+			}
+
+			if stripped, ok := stripKeyword(query.Get("q"), conf.Keyword); ok {
+				query.Set("q", stripped)
+				skipInstance(w, req, query)
+				return
+			}
+		}
+
+		next(w, req)
+	}
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"io"
+	"net/http/httptest"
+	urllib "net/url"
+	"strings"
+	"testing"
+)
+
+func TestStripKeyword(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+		found bool
+	}{
+		{"!skip linux kernel", "linux kernel", true},
+		{"linux  !SKIP kernel", "linux kernel", true},
+		{"linux kernel", "linux kernel", false},
+		{"!skipper", "!skipper", false},
+	}
+
+	for _, test := range tests {
+		got, found := stripKeyword(test.query, "!skip")
+		if got != test.want || found != test.found {
+			t.Errorf("stripKeyword(%q) = %q, %t, want %q, %t", test.query, got, found, test.want, test.found)
+		}
+	}
+}
+
+func TestGetSkipTarget(t *testing.T) {
+	query := urllib.Values{
+		"q":          {"linux kernel"},
+		"categories": {"news"},
+		"pageno":     {"2"},
+	}
+
+	want := "/search?categories=news&pageno=2&q=linux+kernel"
+	if got := getSkipTarget(&profile{}, query); got != want {
+		t.Errorf("getSkipTarget() = %s, want %s", got, want)
+	}
+
+	want = "/privacy/search"
+	if got := getSkipTarget(&profile{Name: "privacy"}, urllib.Values{}); got != want {
+		t.Errorf("getSkipTarget() = %s, want %s", got, want)
+	}
+}
+
+func TestReadSearchQuery(t *testing.T) {
+	req := httptest.NewRequest("POST", "/search", strings.NewReader("q=%21skip+linux&pageno=2"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	query, err := readSearchQuery(req)
+	if err != nil {
+		t.Fatalf("readSearchQuery() error: %s", err.Error())
+	}
+	if got := query.Get("q"); got != "!skip linux" {
+		t.Errorf("readSearchQuery() q = %q, want %q", got, "!skip linux")
+	}
+
+	// The next handler still needs the form
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != "q=%21skip+linux&pageno=2" {
+		t.Errorf("Body after readSearchQuery() = %q", body)
+	}
+
+	req = httptest.NewRequest("GET", "/search?q=linux", nil)
+	if query, _ := readSearchQuery(req); query.Get("q") != "linux" {
+		t.Errorf("readSearchQuery() q = %q, want %q", query.Get("q"), "linux")
+	}
+}
@@ -6,24 +6,66 @@ import (
 	"io"
 	"log"
 	"net/http"
+	urllib "net/url"
 	"os"
 	"time"
+
+	"gitlab.com/Njinx/instx/config"
 )
 
+// Get the instance the client making $req should use for the search $query.
+// Only searches move the selection strategy along, so other pages, search
+// suggestions and the like stay on the instance in use.
+func getClientUrl(w http.ResponseWriter, req *http.Request, query urllib.Values) string {
+	if config.ParseConfig().Proxy.Sticky.Enabled {
+		return getStickyUrl(getProfile(req), getClientId(w, req))
+	} else if isSearch(req, query) {
+		return getUrl(getProfile(req), getLanguageFilter(req, query))
+	} else {
+		return getCurrentUrl(getProfile(req), getLanguageFilter(req, query))
+	}
+}
+
+// Whether $req is a search for $query once `proxy.rewrite` is applied, as
+// opposed to e.g. /preferences or /autocompleter
+func isSearch(req *http.Request, query urllib.Values) bool {
+	target := *req.URL
+	target.RawQuery = query.Encode()
+	rewriteSearch(&target, rewriters)
+
+	return target.Path == "/search" && len(target.Query().Get("q")) > 0
+}
+
+// Get the URL of $requestUri on the instance at $url. Searches go through
+// `proxy.rewrite` and get $p's preferences.
+func craftUrl(p *profile, url string, requestUri string) string {
+	target, err := urllib.ParseRequestURI(requestUri)
+	if err != nil {
+		log.Printf("Could not parse URL \"%s\": %s\n", requestUri, err.Error())
+		return url + requestUri
+	}
+
+	rewriteSearch(target, rewriters)
+
+	query := target.Query()
+	if query.Has("q") {
+		applyPreferences(p, query)
+		target.RawQuery = query.Encode()
+	}
+
+	return url + target.String()
+}
+
 // Redirect the user to the current instance with their search query
 // and preferences URL.
 func redirectHandler(w http.ResponseWriter, req *http.Request) {
-	url := getUrl()
-
-	var craftedUrl string
-	if len(preferencesData) > 0 {
-		craftedUrl = fmt.Sprintf(
-			"%s%s&preferences=%s",
-			url,
-			req.RequestURI,
-			preferencesData)
-	} else {
-		craftedUrl = fmt.Sprintf("%s%s", url, req.RequestURI)
+	url := getClientUrl(w, req, req.URL.Query())
+	craftedUrl := craftUrl(getProfile(req), url, req.RequestURI)
+	rememberLastUrl(w, getProfile(req), url)
+
+	if config.ParseConfig().Proxy.Interstitial.Enabled {
+		fallbackHandler(w, req, url)
+		return
 	}
 
 	w.Header().Add("location", craftedUrl)
@@ -36,6 +78,37 @@ func redirectHandler(w http.ResponseWriter, req *http.Request) {
 	w.Write([]byte(fmt.Sprintf(REDIRECT_HTML_FMT, craftedUrl)))
 }
 
+// A canidate offered by the fallback page
+type fallbackLink struct {
+	Url    string `json:"url"`
+	Target string `json:"target"`
+}
+
+// Serve a page that sends the browser to the first of the top canidates
+// that answers within `proxy.interstitial.timeout` seconds, starting with
+// $first. Links to each one are shown in case none do.
+func fallbackHandler(w http.ResponseWriter, req *http.Request, first string) {
+	conf := config.ParseConfig().Proxy.Interstitial
+
+	p := getProfile(req)
+	var links []fallbackLink
+	for _, url := range getFallbackUrls(p, first, conf.MaxCanidates) {
+		links = append(links, fallbackLink{url, craftUrl(p, url, req.RequestURI)})
+	}
+
+	linksJson, err := json.Marshal(links)
+	if err != nil {
+		log.Printf("Could not marshal fallback links: %s\n", err.Error())
+		linksJson = []byte("[]")
+	}
+
+	serveTemplate(w, req, "/fallback", "text/html", struct {
+		Links     []fallbackLink
+		LinksJson string
+		Timeout   int64
+	}{links, string(linksJson), conf.Timeout * 1000})
+}
+
 func openSearchXmlHandler(w http.ResponseWriter, req *http.Request) {
 	serveFile(w, req, "/opensearch.xml", "application/opensearchdescription+xml")
 }
@@ -85,7 +158,7 @@ func commandHandler(w http.ResponseWriter, req *http.Request) {
 	}
 
 	var commandResponse CommandResponse
-	cmdResp, err := callCommand(commandRequest.Name)
+	cmdResp, err := callCommand(commandRequest.Name, commandRequest.Body)
 	commandResponse.Body = cmdResp
 	if err != nil {
 		commandResponse.Err = err.Error()
@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsSearch(t *testing.T) {
+	defer func(saved []Rewriter) { rewriters = saved }(rewriters)
+	rewriters = []Rewriter{&RemapRewriter{From: "/", To: "/search"}}
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"/search?q=linux", true},
+		{"/?q=linux", true},
+		{"/search", false},
+		{"/search?q=", false},
+		{"/preferences", false},
+		{"/autocompleter?q=lin", false},
+		{"/static/themes/simple/css/searxng.min.css", false},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.target, nil)
+		if got := isSearch(req, req.URL.Query()); got != test.want {
+			t.Errorf("isSearch(%s) = %t, want %t", test.target, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"log"
+	"time"
+
+	"gitlab.com/Njinx/instx/config"
+	"gitlab.com/Njinx/instx/updater"
+)
+
+// When each canidate taken out of rotation can be used again, by URL. This
+// is kept outside of the canidates since every update replaces them.
+var unhealthyUntil = make(map[string]time.Time)
+
+// Consecutive failed health checks, by URL
+var healthCheckFailures = make(map[string]int)
+
+// Put canidates whose cool-down is over back into the pool and copy the
+// health of every canidate into it so it shows up in instxctl stats.
+// updatedCanidatesMutex must be held.
+func refreshHealth(now time.Time) {
+	for url, until := range unhealthyUntil {
+		if !now.Before(until) {
+			log.Printf("\"%s\" has cooled down, returning it to the pool\n", url)
+			delete(unhealthyUntil, url)
+		}
+	}
+
+	for _, p := range profiles {
+		for elem := p.Canidates.Front(); elem != nil; elem = elem.Next() {
+			if canidate, ok := elem.Value.(updater.Canidate); ok {
+				canidate.UnhealthyUntil = unhealthyUntil[canidate.Url]
+				elem.Value = canidate
+			}
+		}
+	}
+}
+
+// Get the URLs of the canidates in use by any profile, picking one for
+// profiles that don't have any yet
+func getCurrentUrls() []string {
+	updatedCanidatesMutex.Lock()
+	defer updatedCanidatesMutex.Unlock()
+
+	now := time.Now()
+	refreshHealth(now)
+
+	var urls []string
+	seen := make(map[string]bool)
+	for _, p := range profiles {
+		var current []string
+		p.Canidates.Iterate(func(canidate *updater.Canidate) bool {
+			if canidate.IsCurrent && canidate.IsHealthy(now) {
+				current = append(current, canidate.Url)
+			}
+			return false
+		})
+
+		if len(current) == 0 {
+			current = append(current, selectCanidate(p, now))
+		}
+		for _, url := range current {
+			if !seen[url] {
+				seen[url] = true
+				urls = append(urls, url)
+			}
+		}
+	}
+	return urls
+}
+
+// Whether $url is the canidate of $p in use. updatedCanidatesMutex must be
+// held.
+func isCurrentUrl(p *profile, url string) bool {
+	current := false
+	p.Canidates.Iterate(func(canidate *updater.Canidate) bool {
+		current = canidate.IsCurrent && canidate.Url == url
+		return current
+	})
+	return current
+}
+
+// Check the instances in use every `proxy.health_check.interval` seconds.
+// After `proxy.health_check.failures` failures in a row it's skipped for
+// `proxy.health_check.cool_down` minutes and the next canidate takes over,
+// so a dead instance doesn't break searches until the next update.
+func runHealthCheck() {
+	conf := config.ParseConfig().Proxy.HealthCheck
+	interval := time.Duration(conf.Interval) * time.Second
+	timeout := time.Duration(conf.Timeout) * time.Second
+	coolDown := time.Duration(conf.CoolDown) * time.Minute
+
+	for {
+		time.Sleep(interval)
+
+		for _, url := range getCurrentUrls() {
+			err := updater.CheckHealth(url, timeout)
+
+			updatedCanidatesMutex.Lock()
+			if err == nil {
+				delete(healthCheckFailures, url)
+			} else {
+				healthCheckFailures[url]++
+				log.Printf("Health check failed for \"%s\" (%d/%d): %s\n",
+					url, healthCheckFailures[url], conf.Failures, err.Error())
+
+				if healthCheckFailures[url] >= conf.Failures {
+					log.Printf("Marking \"%s\" as unhealthy for %d minutes\n", url, conf.CoolDown)
+					delete(healthCheckFailures, url)
+
+					now := time.Now()
+					unhealthyUntil[url] = now.Add(coolDown)
+					if !config.ParseConfig().Proxy.Sticky.Enabled {
+						for _, p := range profiles {
+							if isCurrentUrl(p, url) {
+								log.Printf("Switched to \"%s\"\n", selectCanidate(p, now))
+							}
+						}
+					}
+				}
+			}
+			updatedCanidatesMutex.Unlock()
+		}
+	}
+}
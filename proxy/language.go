@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"net/http"
+	urllib "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gitlab.com/Njinx/instx/config"
+	"gitlab.com/Njinx/instx/updater"
+)
+
+// Scripts that give away the language of a query. Latin and the like are
+// shared by too many languages to tell.
+var scriptLanguages = []struct {
+	script   *unicode.RangeTable
+	language string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Greek, "el"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+}
+
+// Guess the language of $query from its script. Empty if it can't be told.
+// Japanese is checked before Chinese since it also uses Han characters.
+func detectQueryLanguage(query string) string {
+	for _, entry := range scriptLanguages {
+		for _, r := range query {
+			if unicode.Is(entry.script, r) {
+				return entry.language
+			}
+		}
+	}
+	return ""
+}
+
+// Get the primary language subtag of $tag, e.g. "de" for "de-AT"
+func getPrimaryLanguage(tag string) string {
+	primary, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+	primary = strings.ToLower(primary)
+
+	// Not languages
+	if primary == "all" || primary == "auto" || primary == "*" {
+		return ""
+	}
+	return primary
+}
+
+// Get the language the browser prefers from an Accept-Language header
+func parseAcceptLanguage(header string) string {
+	type weighted struct {
+		language string
+		q        float64
+	}
+
+	var languages []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64); err == nil {
+				q = parsed
+			}
+		}
+
+		if language := getPrimaryLanguage(tag); len(language) > 0 && q > 0 {
+			languages = append(languages, weighted{language, q})
+		}
+	}
+	if len(languages) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].q > languages[j].q
+	})
+	return languages[0].language
+}
+
+// Work out which language the search $query made by $req is in: the
+// language it or the profile's preferences ask for, then the script of the
+// query, then the browser's Accept-Language
+func getSearchLanguage(req *http.Request, query urllib.Values) string {
+	conf := config.ParseConfig().Proxy.LanguageRouting
+
+	if language := getPrimaryLanguage(query.Get("language")); len(language) > 0 {
+		return language
+	}
+	if language := getPrimaryLanguage(getProfile(req).Preferences.Get("language")); len(language) > 0 {
+		return language
+	}
+	if conf.DetectQuery {
+		if language := detectQueryLanguage(query.Get("q")); len(language) > 0 {
+			return language
+		}
+	}
+	return parseAcceptLanguage(req.Header.Get("Accept-Language"))
+}
+
+// Whether $canidate is a good fit for searches covered by $rule
+func matchesLanguageRule(canidate *updater.Canidate, rule *config.LanguageRule) bool {
+	for _, country := range rule.Countries {
+		for _, network := range canidate.Networks {
+			if strings.EqualFold(country, network.Country) {
+				return true
+			}
+		}
+	}
+
+	if url, err := urllib.Parse(canidate.Url); err == nil {
+		host := strings.ToLower(url.Hostname())
+		for _, tld := range rule.Tlds {
+			if strings.HasSuffix(host, "."+strings.ToLower(strings.TrimPrefix(tld, "."))) {
+				return true
+			}
+		}
+	}
+
+	for _, engine := range rule.Engines {
+		for _, enabled := range canidate.Engines {
+			if strings.EqualFold(engine, enabled) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Get what canidates should match to serve the search $query made by $req.
+// nil if language routing is off or there's no rule for the search's
+// language.
+func getLanguageFilter(req *http.Request, query urllib.Values) func(canidate *updater.Canidate) bool {
+	conf := config.ParseConfig().Proxy.LanguageRouting
+	if !conf.Enabled || query.Get("q") == "" {
+		return nil
+	}
+
+	rule, ok := conf.Rules[getSearchLanguage(req, query)]
+	if !ok {
+		return nil
+	}
+	return func(canidate *updater.Canidate) bool {
+		return matchesLanguageRule(canidate, &rule)
+	}
+}
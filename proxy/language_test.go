@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"testing"
+
+	"gitlab.com/Njinx/instx/config"
+	"gitlab.com/Njinx/instx/updater"
+)
+
+func TestDetectQueryLanguage(t *testing.T) {
+	tests := map[string]string{
+		"linux kernel":  "",
+		"東京 天気":         "zh",
+		"東京の天気":         "ja",
+		"погода москва": "ru",
+		"날씨":            "ko",
+	}
+
+	for query, want := range tests {
+		if got := detectQueryLanguage(query); got != want {
+			t.Errorf("detectQueryLanguage(%s) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := map[string]string{
+		"":                              "",
+		"de-AT,de;q=0.9,en;q=0.8":       "de",
+		"en;q=0.5, fr-CA":               "fr",
+		"*;q=0.9, nl;q=0.2":             "nl",
+		"es;q=0, pt-BR;q=0.1, it;q=0.1": "pt",
+	}
+
+	for header, want := range tests {
+		if got := parseAcceptLanguage(header); got != want {
+			t.Errorf("parseAcceptLanguage(%s) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestMatchesLanguageRule(t *testing.T) {
+	rule := config.LanguageRule{
+		Countries: []string{"DE", "AT"},
+		Tlds:      []string{".de"},
+		Engines:   []string{"Qwant"},
+	}
+
+	tests := map[string]struct {
+		canidate updater.Canidate
+		want     bool
+	}{
+		"country": {updater.Canidate{Instance: updater.Instance{Url: "https://searx.example/", Networks: []updater.Network{{Country: "at"}}}}, true},
+		"tld":     {updater.Canidate{Instance: updater.Instance{Url: "https://suche.example.de/"}}, true},
+		"engine":  {updater.Canidate{Instance: updater.Instance{Url: "https://searx.example/", Engines: []string{"qwant"}}}, true},
+		"none":    {updater.Canidate{Instance: updater.Instance{Url: "https://searx.fr/", Networks: []updater.Network{{Country: "FR"}}}}, false},
+	}
+
+	for name, test := range tests {
+		if got := matchesLanguageRule(&test.canidate, &rule); got != test.want {
+			t.Errorf("%s: matchesLanguageRule() = %v, want %v", name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	urllib "net/url"
+	"strings"
+
+	"gitlab.com/Njinx/instx/config"
+)
+
+// SearX's safesearch levels
+var safeSearchLevels = map[string]string{
+	"off":      "0",
+	"moderate": "1",
+	"strict":   "2",
+}
+
+// Translate $conf into the query parameters SearX and SearXNG read
+// preferences from. Unlike a preferences blob these don't depend on which
+// engines the instance has.
+func newPreferenceParams(conf *config.PreferencesConfig) urllib.Values {
+	params := make(urllib.Values)
+
+	setIfAny := func(key string, value string) {
+		if len(value) > 0 {
+			params.Set(key, value)
+		}
+	}
+	setBool := func(key string, value *bool) {
+		if value == nil {
+			return
+		} else if *value {
+			params.Set(key, "1")
+		} else {
+			params.Set(key, "0")
+		}
+	}
+
+	setIfAny("language", conf.Language)
+	setIfAny("safesearch", safeSearchLevels[strings.ToLower(conf.SafeSearch)])
+	setIfAny("categories", strings.Join(conf.Categories, ","))
+	setIfAny("engines", strings.Join(conf.Engines, ","))
+	setIfAny("time_range", strings.ToLower(conf.TimeRange))
+	setIfAny("theme", conf.Theme)
+	setIfAny("autocomplete", conf.Autocomplete)
+	setBool("image_proxy", conf.ImageProxy)
+	setBool("results_on_new_tab", conf.ResultsOnNewTab)
+
+	// Instances want "<engine>__<category>". Most engines are in general.
+	var disabled []string
+	for _, engine := range conf.DisabledEngines {
+		if !strings.Contains(engine, "__") {
+			engine += "__general"
+		}
+		disabled = append(disabled, engine)
+	}
+	setIfAny("disabled_engines", strings.Join(disabled, ","))
+
+	return params
+}
+
+// Add $p's preferences to a search's $query. Anything the browser already
+// asked for, e.g. a different category, is left alone.
+func applyPreferences(p *profile, query urllib.Values) {
+	for key, values := range p.Preferences {
+		if !query.Has(key) {
+			query[key] = values
+		}
+	}
+
+	if len(p.PreferencesData) > 0 {
+		query.Set("preferences", p.PreferencesData)
+	}
+}
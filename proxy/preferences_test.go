@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	urllib "net/url"
+	"testing"
+
+	"gitlab.com/Njinx/instx/config"
+)
+
+func TestApplyPreferences(t *testing.T) {
+	newTab := false
+	p := &profile{
+		Preferences: newPreferenceParams(&config.PreferencesConfig{
+			Language:        "en-US",
+			SafeSearch:      "Strict",
+			Categories:      []string{"general", "news"},
+			DisabledEngines: []string{"bing", "flickr__images"},
+			ResultsOnNewTab: &newTab,
+		}),
+	}
+
+	query := urllib.Values{"q": {"go"}, "categories": {"images"}}
+	applyPreferences(p, query)
+
+	want := map[string]string{
+		"language":           "en-US",
+		"safesearch":         "2",
+		"categories":         "images",
+		"disabled_engines":   "bing__general,flickr__images",
+		"results_on_new_tab": "0",
+	}
+	for key, value := range want {
+		if got := query.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	for _, key := range []string{"theme", "time_range", "image_proxy", "preferences"} {
+		if query.Has(key) {
+			t.Errorf("%s shouldn't be set", key)
+		}
+	}
+}
+
+func TestCraftUrl(t *testing.T) {
+	p := &profile{
+		Preferences:     urllib.Values{"language": {"de"}},
+		PreferencesData: "blob",
+	}
+
+	if got, want := craftUrl(p, "https://searx.example", "/search?q=go+lang"), "https://searx.example/search?language=de&preferences=blob&q=go+lang"; got != want {
+		t.Errorf("craftUrl() = %s, want %s", got, want)
+	}
+	if got, want := craftUrl(p, "https://searx.example", "/about"), "https://searx.example/about"; got != want {
+		t.Errorf("craftUrl() = %s, want %s", got, want)
+	}
+}
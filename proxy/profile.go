@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	urllib "net/url"
+	"strings"
+
+	"gitlab.com/Njinx/instx/config"
+	"gitlab.com/Njinx/instx/updater"
+)
+
+// A profile's canidates and how searches are sent to them. Named profiles
+// are served under /<name>/, the default one at the root.
+type profile struct {
+	Name      string
+	Canidates *updater.Canidates
+
+	// Picks which canidate each search goes to
+	Strategy SelectionStrategy
+
+	// The "preferences" parameter of the profile's `preferences_url`
+	PreferencesData string
+
+	// Query parameters from the profile's `preferences`
+	Preferences urllib.Values
+
+	// Client ID -> assignment. Protected by updatedCanidatesMutex.
+	StickyAssignments map[string]stickyAssignment
+}
+
+// Every profile, by name
+var profiles map[string]*profile
+
+func newProfile(name string, canidates *updater.Canidates) *profile {
+	c := config.ParseConfig()
+	conf, _ := c.GetProfile(name)
+
+	return &profile{
+		Name:              name,
+		Canidates:         canidates,
+		Strategy:          getSelectionStrategy(),
+		PreferencesData:   parsePreferences(conf.PreferencesUrl),
+		Preferences:       newPreferenceParams(&conf.Preferences),
+		StickyAssignments: make(map[string]stickyAssignment),
+	}
+}
+
+// Where the profile's pages are, e.g. "/" or "/privacy/"
+func (p *profile) root() string {
+	if p.Name == config.DEFAULT_PROFILE {
+		return "/"
+	}
+	return fmt.Sprintf("/%s/", p.Name)
+}
+
+type profileContextKey struct{}
+
+// Get the profile $req is for
+func getProfile(req *http.Request) *profile {
+	if p, ok := req.Context().Value(profileContextKey{}).(*profile); ok {
+		return p
+	}
+	return profiles[config.DEFAULT_PROFILE]
+}
+
+// Serve $next for profile $p. The profile's path prefix is removed so the
+// handlers see the same paths they would at the root.
+func withProfile(p *profile, next http.Handler) http.Handler {
+	prefix := strings.TrimSuffix(p.root(), "/")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = req.WithContext(context.WithValue(req.Context(), profileContextKey{}, p))
+
+		if len(prefix) > 0 {
+			url := *req.URL
+			url.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, prefix), "/")
+			url.RawPath = ""
+			req.URL = &url
+			req.RequestURI = url.RequestURI()
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
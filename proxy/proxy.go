@@ -2,10 +2,14 @@ package proxy
 
 import (
 	"bytes"
+	"container/list"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	urllib "net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,48 +28,213 @@ const REDIRECT_HTML_FMT = "<HTML><HEAD>" +
 
 var vfs resources.VFS
 
-// The list of current canidates to use
-var updatedCanidates *updater.Canidates
+// Protects the canidates of every profile
 var updatedCanidatesMutex *sync.Mutex
 
-var preferencesData string
+// Pick the canidate of $p to use with the selection strategy and mark it as
+// in use. updatedCanidatesMutex must be held.
+func selectCanidate(p *profile, now time.Time) string {
+	return selectCanidateMatching(p, now, nil)
+}
 
-// Get the current instance URL
-func getUrl() string {
-	var ret string
+// Like selectCanidate, but only healthy canidates that pass $filter are
+// considered. If none do, $filter is ignored.
+func selectCanidateMatching(p *profile, now time.Time, filter func(canidate *updater.Canidate) bool) string {
 
 	// This is bad and shouldn't happen under normal circumstances
-	if updatedCanidates.Len() == 0 {
+	if p.Canidates.Len() == 0 {
 		log.Println("Zero valid instances were found. This isn't normal. Maybe searx.space is down?")
-		ret = config.ParseConfig().DefaultInstance
-	} else {
+		return config.ParseConfig().DefaultInstance
+	}
+
+	refreshHealth(now)
+
+	var elems []*list.Element
+	var healthy []*updater.Canidate
+	for elem := p.Canidates.Front(); elem != nil; elem = elem.Next() {
+		canidate, ok := elem.Value.(updater.Canidate)
+		if !ok {
+			continue
+		}
 
-		// Set the previous canidate as no longer in use
-		updatedCanidates.Iterate(func(canidate *updater.Canidate) bool {
-			if canidate.IsCurrent {
-				canidate.IsCurrent = false
-				return true
-			} else {
-				return false
+		if canidate.IsCurrent {
+			canidate.IsCurrent = false
+			elem.Value = canidate
+		}
+		if canidate.IsHealthy(now) {
+			elems = append(elems, elem)
+			healthy = append(healthy, &canidate)
+		}
+	}
+
+	if filter != nil {
+		var matchingElems []*list.Element
+		var matching []*updater.Canidate
+		for i, canidate := range healthy {
+			if filter(canidate) {
+				matchingElems = append(matchingElems, elems[i])
+				matching = append(matching, canidate)
 			}
-		})
+		}
+		if len(matching) > 0 {
+			elems, healthy = matchingElems, matching
+		}
+	}
+
+	// Every canidate is unhealthy. The best one is still better than nothing.
+	selected := p.Canidates.Front()
+	if len(healthy) > 0 {
+		selected = elems[p.Strategy.Select(healthy, now)]
+	}
+
+	canidate := selected.Value.(updater.Canidate)
+	canidate.IsCurrent = true
+	selected.Value = canidate
+
+	return canidate.Url
+}
+
+// Get up to $n canidates of $p to try in order, starting with $first and
+// followed by the best healthy canidates
+func getFallbackUrls(p *profile, first string, n int) []string {
+	updatedCanidatesMutex.Lock()
+	defer updatedCanidatesMutex.Unlock()
+
+	now := time.Now()
+	urls := []string{first}
+	p.Canidates.Iterate(func(canidate *updater.Canidate) bool {
+		if len(urls) >= n {
+			return true
+		}
+		if canidate.Url != first && canidate.IsHealthy(now) {
+			urls = append(urls, canidate.Url)
+		}
+		return false
+	})
+
+	return urls
+}
 
-		updatedCanidatesMutex.Lock()
+// Get the URL of the instance to send $p's next search to, preferring
+// canidates that pass $filter if it isn't nil
+func getUrl(p *profile, filter func(canidate *updater.Canidate) bool) string {
+	updatedCanidatesMutex.Lock()
+	defer updatedCanidatesMutex.Unlock()
 
-		// Pick the first canidate. The reason the updater exports all canidates
-		// instead of just one is because we may want to do something like provide
-		// fallback instances in the future.
-		canidate := updatedCanidates.Get(0)
-		ret = canidate.Url
-		canidate.IsCurrent = true
+	return selectCanidateMatching(p, time.Now(), filter)
+}
+
+// Like getUrl, but keep using the canidate of $p in use if it's healthy and
+// passes $filter. The selection strategy only moves along if it doesn't.
+func getCurrentUrl(p *profile, filter func(canidate *updater.Canidate) bool) string {
+	updatedCanidatesMutex.Lock()
+	defer updatedCanidatesMutex.Unlock()
+
+	now := time.Now()
+	refreshHealth(now)
+
+	url := ""
+	p.Canidates.Iterate(func(canidate *updater.Canidate) bool {
+		if canidate.IsCurrent && canidate.IsHealthy(now) && (filter == nil || filter(canidate)) {
+			url = canidate.Url
+			return true
+		}
+		return false
+	})
+	if len(url) > 0 {
+		return url
+	}
+
+	return selectCanidateMatching(p, now, filter)
+}
+
+// What the templates in resources can use
+type TemplateData struct {
+	// How the browser reached instx, e.g. "http", "localhost:8080", 8080
+	Scheme string
+	Host   string
+	Port   int
+
+	// Where searches go, e.g. "http://localhost:8080" or
+	// "http://localhost:8080/privacy"
+	BaseUrl string
+
+	// The profile being served. Empty for the default one.
+	Profile string
+
+	// What browsers call the search engine
+	Name string
+
+	// Whether /autocompleter is available
+	Suggestions bool
+
+	// Every other profile, so each one's search engine can be added
+	Profiles []ProfileLink
+}
+
+// Another profile as seen by the templates
+type ProfileLink struct {
+	Name    string
+	BaseUrl string
+}
 
-		updatedCanidatesMutex.Unlock()
+// What browsers call the search engine of profile $name
+func getSearchEngineName(name string) string {
+	if name == config.DEFAULT_PROFILE {
+		return "InstX"
 	}
-	return ret
+	return fmt.Sprintf("InstX (%s)", name)
+}
+
+// Get the template data for $req. The host comes from the request so that
+// instx can be reached by any name, not just localhost.
+func newTemplateData(req *http.Request) TemplateData {
+	conf := config.ParseConfig()
+	p := getProfile(req)
+
+	data := TemplateData{
+		Scheme:      "http",
+		Host:        req.Host,
+		Port:        conf.Proxy.Port,
+		Profile:     p.Name,
+		Name:        getSearchEngineName(p.Name),
+		Suggestions: conf.Proxy.Autocomplete.Enabled,
+	}
+
+	if req.TLS != nil {
+		data.Scheme = "https"
+	} else if proto := req.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		data.Scheme = proto
+	}
+
+	if len(data.Host) == 0 {
+		data.Host = fmt.Sprintf("localhost:%d", conf.Proxy.Port)
+	} else if _, port, err := net.SplitHostPort(data.Host); err == nil {
+		if n, err := strconv.Atoi(port); err == nil {
+			data.Port = n
+		}
+	}
+
+	origin := fmt.Sprintf("%s://%s", data.Scheme, data.Host)
+	data.BaseUrl = origin + strings.TrimSuffix(p.root(), "/")
+	for _, name := range conf.ProfileNames() {
+		if name != p.Name {
+			data.Profiles = append(data.Profiles, ProfileLink{
+				Name:    getSearchEngineName(name),
+				BaseUrl: origin + strings.TrimSuffix(profiles[name].root(), "/"),
+			})
+		}
+	}
+	return data
 }
 
 // Serve static files
 func serveFile(w http.ResponseWriter, req *http.Request, path string, mime string) {
+	serveTemplate(w, req, path, mime, newTemplateData(req))
+}
+
+// Serve a file from the VFS, executed as a template with $data
+func serveTemplate(w http.ResponseWriter, req *http.Request, path string, mime string, data interface{}) {
 
 	// Retrieve the file from the VFS
 	tmpl, err := vfs.GetFile(path)
@@ -75,22 +244,25 @@ func serveFile(w http.ResponseWriter, req *http.Request, path string, mime strin
 		return
 	}
 
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Printf("Could not execute template \"%s\": %s\n", path, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Add("content-type", fmt.Sprintf("%s; charset=UTF-8", mime))
 	w.Header().Add("date", time.Now().Format(time.RFC1123))
 	w.Header().Add("expires", time.Now().Format(time.RFC1123))
 
 	w.WriteHeader(http.StatusOK)
-
-	var buf bytes.Buffer
-	tmpl.Execute(&buf, tmpl)
 	w.Write(buf.Bytes())
 }
 
-// Extract the GET parameter from `preferences_url`
-func parsePreferences() {
-	preferencesRaw := config.ParseConfig().Proxy.PreferencesUrl
+// Extract the GET parameter from a `preferences_url`
+func parsePreferences(preferencesRaw string) string {
 	if len(preferencesRaw) == 0 {
-		return
+		return ""
 	}
 
 	preferencesUrl, err := urllib.Parse(preferencesRaw)
@@ -99,13 +271,13 @@ func parsePreferences() {
 	// early, throw a warning, and continue program execution.
 	if err != nil {
 		log.Printf("Could not parse URL \"%s\": %s\n", preferencesRaw, err.Error())
-		return
+		return ""
 	}
 
 	params, ok := preferencesUrl.Query()["preferences"]
 	if !ok || len(params) < 1 {
 		log.Println("Could not find the \"preferences\" parameter in preferences_url. Perhaps the URL is invalid.")
-		return
+		return ""
 	} else if len(params) > 1 {
 
 		// Warn if there's more than one `preferences` parameter, but continue
@@ -113,21 +285,50 @@ func parsePreferences() {
 		log.Println("Too many \"preferences\" parameters in preferences_url. Perhaps the URL is invalid.")
 	}
 
-	preferencesData = params[0]
+	return params[0]
 }
 
-func Run(updatedCanidatesLocal *updater.Canidates, updatedCanidatesMutexLocal *sync.Mutex) {
+func Run(updatedCanidates updater.ProfileCanidates, updatedCanidatesMutexLocal *sync.Mutex) {
 	vfs = resources.New()
 
-	updatedCanidates = updatedCanidatesLocal
 	updatedCanidatesMutex = updatedCanidatesMutexLocal
 
-	parsePreferences()
+	profiles = make(map[string]*profile)
+	for name, canidates := range updatedCanidates {
+		profiles[name] = newProfile(name, canidates)
+	}
+
+	rewriters = getRewriters()
+
+	if config.ParseConfig().Proxy.HealthCheck.Enabled {
+		go runHealthCheck()
+	}
+
+	// Every profile serves the same pages under its own prefix
+	mux := http.NewServeMux()
+	if strings.ToLower(config.ParseConfig().Proxy.Mode) == "reverse_proxy" {
+		mux.HandleFunc("/", withSkipKeyword(reverseProxyHandler))
+		mux.HandleFunc(UPSTREAM_PREFIX, upstreamHandler)
+	} else {
+		mux.HandleFunc("/", withSkipKeyword(redirectHandler))
+	}
+	if config.ParseConfig().Proxy.Feedback.Enabled {
+		mux.HandleFunc(SKIP_PATH, skipHandler)
+	}
+
+	if conf := config.ParseConfig().Proxy.Autocomplete; conf.Enabled {
+		if conf.CacheSize > 0 {
+			suggestionsCache = newSuggestionCache(conf.CacheSize, time.Duration(conf.CacheTtl)*time.Minute)
+		}
+		mux.HandleFunc("/autocompleter", autocompleteHandler)
+	}
+	mux.HandleFunc("/getstarted", getStartedHandler)
+	mux.HandleFunc("/opensearch.xml", openSearchXmlHandler)
+	mux.HandleFunc("/favicon.ico", faviconHandler)
 
-	http.HandleFunc("/", redirectHandler)
-	http.HandleFunc("/getstarted", getStartedHandler)
-	http.HandleFunc("/opensearch.xml", openSearchXmlHandler)
-	http.HandleFunc("/favicon.ico", faviconHandler)
+	for _, p := range profiles {
+		http.Handle(p.root(), withProfile(p, mux))
+	}
 	http.HandleFunc("/ping", pingHandler)
 	http.HandleFunc("/cmd", commandHandler)
 
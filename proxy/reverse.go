@@ -0,0 +1,347 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	urllib "net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/Njinx/instx/config"
+	"gitlab.com/Njinx/instx/updater"
+)
+
+// Static files and proxied images are served from here, under each
+// profile's prefix, so that they come from the same instance as the page
+// that links to them
+const UPSTREAM_PREFIX = "/_instx/upstream/"
+
+// Don't read more than this much of a page from an instance
+const REVERSE_PROXY_MAX_BODY = 8 << 20
+
+// Don't forward more than this much of a request body (e.g. a POST search)
+const REVERSE_PROXY_MAX_REQUEST_BODY = 1 << 20
+
+// Request headers passed on to instances. Everything else, including
+// cookies, the User-Agent, Referer, Accept-Language and client hints, is
+// dropped. The search language comes from `proxy.preferences` instead.
+var forwardedRequestHeaders = []string{
+	"Accept", "Content-Type",
+}
+
+type ErrResponseTooLarge struct {
+	Url string
+}
+
+func (err *ErrResponseTooLarge) Error() string {
+	return fmt.Sprintf("Response from \"%s\" is larger than %d bytes", err.Url, REVERSE_PROXY_MAX_BODY)
+}
+
+// Read the body of $resp, or fail if it's larger than REVERSE_PROXY_MAX_BODY
+// rather than serving a truncated page
+func readUpstreamBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, REVERSE_PROXY_MAX_BODY+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > REVERSE_PROXY_MAX_BODY {
+		return nil, &ErrResponseTooLarge{resp.Request.URL.String()}
+	}
+	return body, nil
+}
+
+// Response headers passed back to the browser. Cookies aren't, since the
+// instance can change from one search to the next.
+var forwardedResponseHeaders = []string{
+	"Content-Type", "Content-Language", "Content-Disposition",
+	"Cache-Control", "Expires",
+}
+
+func newUpstreamClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+
+		// Redirects are passed on to the browser after rewriting them
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Build a request for $path on the instance at $base, keeping only what the
+// instance needs from the browser's request
+func newUpstreamRequest(req *http.Request, base *urllib.URL, path string, body []byte) (*http.Request, error) {
+	target := *base
+	target.Path = strings.TrimSuffix(base.Path, "/") + path
+	target.RawQuery = req.URL.RawQuery
+
+	upstreamReq, err := http.NewRequest(req.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range forwardedRequestHeaders {
+		if value := req.Header.Get(key); len(value) > 0 {
+			upstreamReq.Header.Set(key, value)
+		}
+	}
+	upstreamReq.Header.Set("User-Agent", updater.SEARCH_PROBE_USER_AGENT)
+
+	return upstreamReq, nil
+}
+
+func getMediaType(header http.Header) string {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// Whether URLs in a response need rewriting
+func isRewritable(header http.Header) bool {
+	switch getMediaType(header) {
+	case "text/html", "text/css":
+		return true
+	default:
+		return false
+	}
+}
+
+// Base URL -> regexp matching links to its pages, static files and proxied
+// images
+var urlRegexps sync.Map
+
+func getUrlRegexp(origin string, basePath string) *regexp.Regexp {
+	if cached, ok := urlRegexps.Load(origin + basePath); ok {
+		return cached.(*regexp.Regexp)
+	}
+
+	urlRegexp := regexp.MustCompile(`(["'(=,\s])(` + regexp.QuoteMeta(origin) + `)?` +
+		regexp.QuoteMeta(basePath) + `/(static/|image_proxy|/)?`)
+	urlRegexps.Store(origin+basePath, urlRegexp)
+	return urlRegexp
+}
+
+// Point URLs in a page from the instance at $base at instx instead. Static
+// files and proxied images go through UPSTREAM_PREFIX, everything else
+// goes through the reverse proxy again. Both are under $root, the profile's
+// prefix.
+func rewriteUpstreamUrls(body []byte, base *urllib.URL, root string) []byte {
+	origin := fmt.Sprintf("%s://%s", base.Scheme, base.Host)
+	basePath := strings.TrimSuffix(base.Path, "/")
+	upstreamPrefix := strings.TrimSuffix(root, "/") + UPSTREAM_PREFIX + base.Host + basePath + "/"
+
+	urlRegexp := getUrlRegexp(origin, basePath)
+	body = urlRegexp.ReplaceAllFunc(body, func(match []byte) []byte {
+		groups := urlRegexp.FindSubmatch(match)
+		delimiter, absolute, rest := string(groups[1]), len(groups[2]) > 0, string(groups[3])
+
+		switch {
+		case rest == "static/" || rest == "image_proxy":
+			return []byte(delimiter + upstreamPrefix + rest)
+
+		// Protocol-relative URLs are on other hosts
+		case rest == "/" && !absolute:
+			return match
+
+		// Only assets are listed like this, e.g. in srcset. Anything else
+		// preceded by a space is likely just text.
+		case delimiter == "," || strings.TrimSpace(delimiter) == "":
+			return match
+		default:
+			return []byte(delimiter + root + strings.TrimPrefix(rest, "/"))
+		}
+	})
+
+	return bytes.ReplaceAll(body, []byte(origin+basePath+"/"), []byte(root))
+}
+
+// Copy the headers the browser should see
+func copyUpstreamHeaders(w http.ResponseWriter, header http.Header, base *urllib.URL, root string) {
+	for _, key := range forwardedResponseHeaders {
+		if value := header.Get(key); len(value) > 0 {
+			w.Header().Set(key, value)
+		}
+	}
+	if location := header.Get("Location"); len(location) > 0 {
+		w.Header().Set("Location", string(rewriteUpstreamUrls([]byte(`"`+location), base, root)[1:]))
+	}
+}
+
+// Send a response read from the instance at $base to the browser
+func writeUpstreamResponse(w http.ResponseWriter, status int, header http.Header, body []byte, base *urllib.URL, root string) {
+	copyUpstreamHeaders(w, header, base, root)
+	if isRewritable(header) {
+		body = rewriteUpstreamUrls(body, base, root)
+	}
+
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Request $path from the instance at $base. The caller closes the body.
+func fetchUpstream(req *http.Request, base *urllib.URL, path string, body []byte, timeout time.Duration) (*http.Response, error) {
+	upstreamReq, err := newUpstreamRequest(req, base, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return newUpstreamClient(timeout).Do(upstreamReq)
+}
+
+// Request $path from the instance at $base and read the response. Error
+// pages, CAPTCHAs and rate limits count as errors.
+func searchUpstream(req *http.Request, base *urllib.URL, body []byte, timeout time.Duration) (*http.Response, []byte, error) {
+	resp, err := fetchUpstream(req, base, req.URL.Path, body, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readUpstreamBody(resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if getMediaType(resp.Header) == "text/html" {
+		err = updater.CheckBlocked(resp.StatusCode, respBody)
+	} else if resp.StatusCode >= 500 {
+		err = &updater.ErrUnexpectedStatus{Url: base.String(), Status: resp.Status}
+	}
+
+	return resp, respBody, err
+}
+
+// Whether $req is a search form being POSTed, which SearX(NG)'s results
+// pages do
+func isFormSearch(req *http.Request) bool {
+	return req.Method == http.MethodPost && getMediaType(req.Header) == "application/x-www-form-urlencoded"
+}
+
+// Run the search in $req through `proxy.rewrite` and $p's preferences. The
+// search is taken from the form in $body if it was POSTed, otherwise from
+// the URL. req.URL is updated and the rewritten search returned.
+func prepareSearch(req *http.Request, body []byte, p *profile) *urllib.URL {
+	search := *req.URL
+	if isFormSearch(req) {
+		search.RawQuery = string(body)
+	}
+
+	rewriteSearch(&search, rewriters)
+	query := search.Query()
+	if query.Has("q") {
+		applyPreferences(p, query)
+		search.RawQuery = query.Encode()
+	}
+
+	target := *req.URL
+	target.Path = search.Path
+	target.RawPath = search.RawPath
+	if !isFormSearch(req) {
+		target.RawQuery = search.RawQuery
+	}
+	req.URL = &target
+
+	return &search
+}
+
+// Forward the search to the client's instance and send back the results.
+// If the instance errors, times out or shows a CAPTCHA, the next canidate
+// is tried, up to `proxy.reverse_proxy.max_attempts` instances.
+func reverseProxyHandler(w http.ResponseWriter, req *http.Request) {
+	conf := config.ParseConfig().Proxy.ReverseProxy
+	timeout := time.Duration(conf.Timeout) * time.Second
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, REVERSE_PROXY_MAX_REQUEST_BODY))
+	if err != nil {
+		log.Printf("Could not read request body: %s\n", err.Error())
+		http.Error(w, "Could not read request body", http.StatusBadRequest)
+		return
+	}
+
+	p := getProfile(req)
+	search := prepareSearch(req, body, p)
+	if isFormSearch(req) {
+		body = []byte(search.RawQuery)
+	}
+
+	lastErr := fmt.Errorf("No instances available")
+	for _, url := range getFallbackUrls(p, getClientUrl(w, req, search.Query()), conf.MaxAttempts) {
+		base, err := urllib.Parse(url)
+		if err != nil {
+			log.Printf("Could not parse URL \"%s\": %s\n", url, err.Error())
+			continue
+		}
+
+		resp, respBody, err := searchUpstream(req, base, body, timeout)
+		if err != nil {
+			log.Printf("Could not search on \"%s\", trying the next instance: %s\n", url, err.Error())
+			lastErr = err
+			continue
+		}
+
+		rememberLastUrl(w, p, url)
+		writeUpstreamResponse(w, resp.StatusCode, resp.Header, respBody, base, p.root())
+		return
+	}
+
+	http.Error(w, fmt.Sprintf("Every instance failed. Last error: %s", lastErr.Error()), http.StatusBadGateway)
+}
+
+// Serve static files and proxied images from the instance named in the
+// path: UPSTREAM_PREFIX/<host>/<path>. Only canidates of the profile can be
+// reached this way so instx can't be used as an open proxy.
+func upstreamHandler(w http.ResponseWriter, req *http.Request) {
+	host, path, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, UPSTREAM_PREFIX), "/")
+	p := getProfile(req)
+
+	var base *urllib.URL
+	updatedCanidatesMutex.Lock()
+	p.Canidates.Iterate(func(canidate *updater.Canidate) bool {
+		if url, err := urllib.Parse(canidate.Url); err == nil && url.Host == host {
+			base = url
+			return true
+		}
+		return false
+	})
+	updatedCanidatesMutex.Unlock()
+
+	if base == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	// $path already includes the instance's base path
+	origin := &urllib.URL{Scheme: base.Scheme, Host: base.Host}
+	timeout := time.Duration(config.ParseConfig().Proxy.ReverseProxy.Timeout) * time.Second
+	resp, err := fetchUpstream(req, origin, "/"+path, nil, timeout)
+	if err != nil {
+		log.Printf("Could not fetch \"%s\" from \"%s\": %s\n", path, host, err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if isRewritable(resp.Header) {
+		body, err := readUpstreamBody(resp)
+		if err != nil {
+			log.Printf("Could not fetch \"%s\" from \"%s\": %s\n", path, host, err.Error())
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeUpstreamResponse(w, resp.StatusCode, resp.Header, body, base, p.root())
+		return
+	}
+
+	// Images and the like are streamed as is
+	copyUpstreamHeaders(w, resp.Header, base, p.root())
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
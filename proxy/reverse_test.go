@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	urllib "net/url"
+	"testing"
+)
+
+func TestRewriteUpstreamUrls(t *testing.T) {
+	base, _ := urllib.Parse("https://searx.example/searx/")
+
+	tests := map[string]string{
+		`<link href="/searx/static/css/logicodev.css">`:               `<link href="/_instx/upstream/searx.example/searx/static/css/logicodev.css">`,
+		`<img src="https://searx.example/searx/image_proxy?url=x">`:   `<img src="/_instx/upstream/searx.example/searx/image_proxy?url=x">`,
+		`<a href="https://searx.example/searx/search?q=go&pageno=2">`: `<a href="/search?q=go&pageno=2">`,
+		`background: url(/searx/static/img/bg.png);`:                  `background: url(/_instx/upstream/searx.example/searx/static/img/bg.png);`,
+		`<a href="https://other.example/searx/static/x.css">`:         `<a href="https://other.example/searx/static/x.css">`,
+	}
+
+	for in, want := range tests {
+		if got := string(rewriteUpstreamUrls([]byte(in), base, "/")); got != want {
+			t.Errorf("rewriteUpstreamUrls(%s) = %s, want %s", in, got, want)
+		}
+	}
+
+	// Pages and static files of a profile link back to its prefix
+	profileTests := map[string]string{
+		`<a href="https://searx.example/searx/search?q=go">`: `<a href="/privacy/search?q=go">`,
+		`<a href="/searx/search?q=go">`:                      `<a href="/privacy/search?q=go">`,
+		`<form action="/searx/preferences">`:                 `<form action="/privacy/preferences">`,
+		`background: url(/searx/static/img/bg.png);`:         `background: url(/privacy/_instx/upstream/searx.example/searx/static/img/bg.png);`,
+	}
+	for in, want := range profileTests {
+		if got := string(rewriteUpstreamUrls([]byte(in), base, "/privacy/")); got != want {
+			t.Errorf("rewriteUpstreamUrls(%s) = %s, want %s", in, got, want)
+		}
+	}
+
+	// Instances without a base path
+	base, _ = urllib.Parse("https://searx.example/")
+	rootTests := map[string]string{
+		`<a href="/search?q=go">`:                   `<a href="/privacy/search?q=go">`,
+		`<link href="/static/css/searxng.min.css">`: `<link href="/privacy/_instx/upstream/searx.example/static/css/searxng.min.css">`,
+		`<script src="//cdn.example/x.js">`:         `<script src="//cdn.example/x.js">`,
+		`<p>1 / 2</p>`:                              `<p>1 / 2</p>`,
+	}
+	for in, want := range rootTests {
+		if got := string(rewriteUpstreamUrls([]byte(in), base, "/privacy/")); got != want {
+			t.Errorf("rewriteUpstreamUrls(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestCopyUpstreamHeadersRewritesLocation(t *testing.T) {
+	base, _ := urllib.Parse("https://searx.example/searx/")
+
+	tests := map[string]string{
+		"/searx/search?q=go":                      "/privacy/search?q=go",
+		"https://searx.example/searx/search?q=go": "/privacy/search?q=go",
+		"https://other.example/":                  "https://other.example/",
+	}
+	for location, want := range tests {
+		w := httptest.NewRecorder()
+		copyUpstreamHeaders(w, http.Header{"Location": {location}}, base, "/privacy/")
+		if got := w.Header().Get("Location"); got != want {
+			t.Errorf("Location %s became %s, want %s", location, got, want)
+		}
+	}
+}
+
+func TestReadUpstreamBody(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://searx.example/search", nil)
+	newResponse := func(size int) *http.Response {
+		return &http.Response{
+			Body:    io.NopCloser(bytes.NewReader(make([]byte, size))),
+			Request: req,
+		}
+	}
+
+	if body, err := readUpstreamBody(newResponse(REVERSE_PROXY_MAX_BODY)); err != nil || len(body) != REVERSE_PROXY_MAX_BODY {
+		t.Errorf("read %d bytes (%v), want %d", len(body), err, REVERSE_PROXY_MAX_BODY)
+	}
+	if _, err := readUpstreamBody(newResponse(REVERSE_PROXY_MAX_BODY + 1)); err == nil {
+		t.Error("responses over the limit should be an error")
+	}
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	urllib "net/url"
+	"sort"
+	"strings"
+
+	"gitlab.com/Njinx/instx/config"
+)
+
+// Query parameters SearX and SearXNG understand. The strip rewriter keeps
+// only these unless told otherwise.
+var SEARX_QUERY_PARAMS = []string{
+	"q", "pageno", "categories", "engines", "language", "locale",
+	"time_range", "safesearch", "format", "theme", "image_proxy",
+	"results_on_new_tab", "autocomplete", "enabled_engines",
+	"disabled_engines", "enabled_plugins", "disabled_plugins", "preferences",
+}
+
+// One step of `proxy.rewrite`. Searches go through every step in order
+// before they're sent to an instance.
+type Rewriter interface {
+
+	// Change $target, whose query is $query
+	Rewrite(target *urllib.URL, query urllib.Values)
+	String() string
+}
+
+type ErrUnknownRewriter struct {
+	Type string
+}
+
+func (err *ErrUnknownRewriter) Error() string {
+	return fmt.Sprintf("Unknown rewrite type: \"%s\"", err.Type)
+}
+
+// Sets parameters the search doesn't have
+type DefaultsRewriter struct {
+	Params map[string]string
+}
+
+func (r *DefaultsRewriter) Rewrite(target *urllib.URL, query urllib.Values) {
+	for key, value := range r.Params {
+		if !query.Has(key) && len(value) > 0 {
+			query.Set(key, value)
+		}
+	}
+}
+
+func (r *DefaultsRewriter) String() string {
+	return "defaults"
+}
+
+// Sends searches made to one path to another, e.g. / to /search
+type RemapRewriter struct {
+	From string
+	To   string
+}
+
+func (r *RemapRewriter) Rewrite(target *urllib.URL, query urllib.Values) {
+	if target.Path == r.From {
+		target.Path = r.To
+		target.RawPath = ""
+	}
+}
+
+func (r *RemapRewriter) String() string {
+	return fmt.Sprintf("remap %s -> %s", r.From, r.To)
+}
+
+// Removes parameters instances don't know about, e.g. tracking parameters
+type StripRewriter struct {
+	Allowed map[string]bool
+}
+
+func (r *StripRewriter) Rewrite(target *urllib.URL, query urllib.Values) {
+	for key := range query {
+
+		// Old SearX selects categories with category_<name>=on
+		if !r.Allowed[key] && !strings.HasPrefix(key, "category_") {
+			query.Del(key)
+		}
+	}
+}
+
+func (r *StripRewriter) String() string {
+	return "strip"
+}
+
+// Turns words like "!img" in the search into categories
+type BangRewriter struct {
+
+	// Bang without the "!" -> category
+	Bangs map[string]string
+}
+
+func (r *BangRewriter) Rewrite(target *urllib.URL, query urllib.Values) {
+	var categories []string
+	var kept []string
+	for _, word := range strings.Fields(query.Get("q")) {
+		if category, ok := r.Bangs[strings.ToLower(strings.TrimPrefix(word, "!"))]; ok && strings.HasPrefix(word, "!") {
+			categories = append(categories, category)
+		} else {
+			kept = append(kept, word)
+		}
+	}
+
+	// A search of nothing but bangs would be empty, so leave it to the
+	// instance
+	if len(categories) > 0 && len(kept) > 0 {
+		query.Set("q", strings.Join(kept, " "))
+		query.Set("categories", strings.Join(categories, ","))
+	}
+}
+
+func (r *BangRewriter) String() string {
+	bangs := make([]string, 0, len(r.Bangs))
+	for bang := range r.Bangs {
+		bangs = append(bangs, "!"+bang)
+	}
+	sort.Strings(bangs)
+	return fmt.Sprintf("bangs %s", strings.Join(bangs, ", "))
+}
+
+func NewRewriter(conf config.RewriteConfig) (Rewriter, error) {
+	switch strings.ToLower(conf.Type) {
+	case "defaults":
+		return &DefaultsRewriter{conf.Params}, nil
+	case "remap":
+		return &RemapRewriter{conf.From, conf.To}, nil
+	case "strip":
+		allowed := conf.Allowed
+		if len(allowed) == 0 {
+			allowed = SEARX_QUERY_PARAMS
+		}
+
+		rewriter := &StripRewriter{make(map[string]bool)}
+		for _, key := range allowed {
+			rewriter.Allowed[key] = true
+		}
+		return rewriter, nil
+	case "bangs":
+		rewriter := &BangRewriter{make(map[string]string)}
+		for bang, category := range conf.Bangs {
+			rewriter.Bangs[strings.ToLower(strings.TrimPrefix(bang, "!"))] = category
+		}
+		return rewriter, nil
+	default:
+		return nil, &ErrUnknownRewriter{conf.Type}
+	}
+}
+
+// Get the steps of `proxy.rewrite`
+func getRewriters() []Rewriter {
+	var ret []Rewriter
+	for _, rewriteConf := range config.ParseConfig().Proxy.Rewrite {
+		rewriter, err := NewRewriter(rewriteConf)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		ret = append(ret, rewriter)
+	}
+	return ret
+}
+
+// The steps of `proxy.rewrite`, in order
+var rewriters []Rewriter
+
+// Run the search at $target through $steps. Anything that isn't a search
+// is left alone.
+func rewriteSearch(target *urllib.URL, steps []Rewriter) {
+	query := target.Query()
+	if !query.Has("q") {
+		return
+	}
+
+	for _, rewriter := range steps {
+		rewriter.Rewrite(target, query)
+	}
+	target.RawQuery = query.Encode()
+}
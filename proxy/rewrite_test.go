@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net/http"
+	urllib "net/url"
+	"strings"
+	"testing"
+
+	"gitlab.com/Njinx/instx/config"
+)
+
+func TestRewriteSearch(t *testing.T) {
+	var steps []Rewriter
+	for _, conf := range []config.RewriteConfig{
+		{Type: "remap", From: "/", To: "/search"},
+		{Type: "bangs", Bangs: map[string]string{"img": "images", "!vid": "videos"}},
+		{Type: "strip"},
+		{Type: "defaults", Params: map[string]string{"language": "en", "categories": "general"}},
+	} {
+		rewriter, err := NewRewriter(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		steps = append(steps, rewriter)
+	}
+
+	tests := map[string]string{
+		"/?q=go":                           "/search?categories=general&language=en&q=go",
+		"/search?q=!img+cats&utm_source=x": "/search?categories=images&language=en&q=cats",
+		"/search?q=cats+!VID&language=de":  "/search?categories=videos&language=de&q=cats",
+		"/search?q=!images+cats":           "/search?categories=general&language=en&q=%21images+cats",
+		"/search?q=!img":                   "/search?categories=general&language=en&q=%21img",
+		"/about?utm_source=x":              "/about?utm_source=x",
+	}
+
+	for in, want := range tests {
+		target, _ := urllib.ParseRequestURI(in)
+		rewriteSearch(target, steps)
+		if got := target.String(); got != want {
+			t.Errorf("rewriteSearch(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestPrepareSearch(t *testing.T) {
+	bangs, _ := NewRewriter(config.RewriteConfig{Type: "bangs", Bangs: map[string]string{"img": "images"}})
+	saved := rewriters
+	rewriters = []Rewriter{bangs}
+	defer func() { rewriters = saved }()
+
+	p := &profile{Preferences: urllib.Values{"language": {"de"}}}
+	want := "categories=images&language=de&q=cats"
+
+	// Searches in the URL
+	req, _ := http.NewRequest("GET", "/search?q=!img+cats", nil)
+	if got := prepareSearch(req, nil, p).RawQuery; got != want {
+		t.Errorf("GET search = %s, want %s", got, want)
+	}
+	if got := req.URL.RawQuery; got != want {
+		t.Errorf("GET request query = %s, want %s", got, want)
+	}
+
+	// Searches POSTed from a results page
+	body := "q=%21img+cats"
+	req, _ = http.NewRequest("POST", "/search", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if got := prepareSearch(req, []byte(body), p).RawQuery; got != want {
+		t.Errorf("POST search = %s, want %s", got, want)
+	}
+	if got := req.URL.RawQuery; got != "" {
+		t.Errorf("POST request query = %s, want none", got)
+	}
+}
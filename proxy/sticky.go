@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitlab.com/Njinx/instx/config"
+	"gitlab.com/Njinx/instx/updater"
+)
+
+// Cookie used to tell clients apart in cookie mode
+const STICKY_COOKIE = "instx_client"
+
+// Which canidate a client was given and until when
+type stickyAssignment struct {
+	Url     string
+	Expires time.Time
+}
+
+// Mixed into hashed addresses so that client IDs can't be reversed with a
+// lookup table
+var stickySalt = newClientId()
+
+func newClientId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Could not generate client ID: %s\n", err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Work out who's searching. In cookie mode new clients are given a cookie.
+func getClientId(w http.ResponseWriter, req *http.Request) string {
+	conf := config.ParseConfig().Proxy.Sticky
+
+	if strings.ToLower(conf.IdentifyBy) == "cookie" {
+		if cookie, err := req.Cookie(STICKY_COOKIE); err == nil && len(cookie.Value) > 0 {
+			return cookie.Value
+		}
+
+		id := newClientId()
+		http.SetCookie(w, &http.Cookie{
+			Name:     STICKY_COOKIE,
+			Value:    id,
+			Path:     "/",
+			MaxAge:   int(conf.Period * 60),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return id
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	sum := sha256.Sum256([]byte(stickySalt + host))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get the URL of the canidate of $p assigned to $clientId, assigning one if
+// needed. Clients keep their canidate for `proxy.sticky.period` minutes
+// unless it drops out of the ranking or fails the health check. New
+// assignments go to whichever of the top canidates has the fewest clients.
+func getStickyUrl(p *profile, clientId string) string {
+	updatedCanidatesMutex.Lock()
+	defer updatedCanidatesMutex.Unlock()
+
+	now := time.Now()
+	refreshHealth(now)
+
+	var healthy []*updater.Canidate
+	p.Canidates.Iterate(func(canidate *updater.Canidate) bool {
+		if canidate.IsHealthy(now) {
+			healthy = append(healthy, canidate)
+		}
+		return false
+	})
+	if len(healthy) == 0 {
+		return selectCanidate(p, now)
+	}
+
+	clients := make(map[string]int)
+	for id, assignment := range p.StickyAssignments {
+		if !now.Before(assignment.Expires) {
+			delete(p.StickyAssignments, id)
+		} else {
+			clients[assignment.Url]++
+		}
+	}
+
+	url := ""
+	if assignment, ok := p.StickyAssignments[clientId]; ok {
+		for _, canidate := range healthy {
+			if canidate.Url == assignment.Url {
+				url = assignment.Url
+				break
+			}
+		}
+	}
+
+	if url == "" {
+		if assignment, ok := p.StickyAssignments[clientId]; ok {
+			clients[assignment.Url]--
+		}
+
+		best := healthy[0]
+		for _, canidate := range healthy[:topN(healthy, config.ParseConfig().Proxy.Selection.TopN)] {
+			if clients[canidate.Url] < clients[best.Url] {
+				best = canidate
+			}
+		}
+
+		url = best.Url
+		p.StickyAssignments[clientId] = stickyAssignment{
+			Url:     url,
+			Expires: now.Add(time.Duration(config.ParseConfig().Proxy.Sticky.Period) * time.Minute),
+		}
+		clients[url]++
+	}
+
+	// Every canidate with clients is in use, and gets health checked
+	for elem := p.Canidates.Front(); elem != nil; elem = elem.Next() {
+		if canidate, ok := elem.Value.(updater.Canidate); ok {
+			canidate.IsCurrent = clients[canidate.Url] > 0
+			elem.Value = canidate
+		}
+	}
+
+	return url
+}
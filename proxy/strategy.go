@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"gitlab.com/Njinx/instx/config"
+	"gitlab.com/Njinx/instx/updater"
+)
+
+// Decides which canidate serves the next search. Strategies keep state
+// between calls, which is protected by updatedCanidatesMutex.
+type SelectionStrategy interface {
+	// Get the index of the canidate to use. $canidates are the healthy
+	// canidates in ranking order and are never empty.
+	Select(canidates []*updater.Canidate, now time.Time) int
+	String() string
+}
+
+type ErrUnknownStrategy struct {
+	Type string
+}
+
+func (err *ErrUnknownStrategy) Error() string {
+	return fmt.Sprintf("Unknown selection strategy: \"%s\"", err.Type)
+}
+
+// Always use the best canidate
+type BestStrategy struct{}
+
+func (s *BestStrategy) Select(canidates []*updater.Canidate, now time.Time) int {
+	return 0
+}
+
+func (s *BestStrategy) String() string {
+	return "best"
+}
+
+// Take turns between the best $TopN canidates
+type RoundRobinStrategy struct {
+	TopN int
+
+	next int
+}
+
+func (s *RoundRobinStrategy) Select(canidates []*updater.Canidate, now time.Time) int {
+	i := s.next % topN(canidates, s.TopN)
+	s.next = i + 1
+	return i
+}
+
+func (s *RoundRobinStrategy) String() string {
+	return "round_robin"
+}
+
+// Pick one of the best $TopN canidates at random, weighted by score. Scores
+// are scaled so the best canidate gets 1 + WEIGHTED_RANDOM_FLOOR shares and
+// the worst WEIGHTED_RANDOM_FLOOR. Raw scores aren't used since one close to
+// 0 would take nearly every search.
+type WeightedRandomStrategy struct {
+	TopN int
+
+	rand *rand.Rand
+}
+
+// Shares the worst of the top canidates still gets
+const WEIGHTED_RANDOM_FLOOR = 0.1
+
+func (s *WeightedRandomStrategy) Select(canidates []*updater.Canidate, now time.Time) int {
+	n := topN(canidates, s.TopN)
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, canidate := range canidates[:n] {
+		lo = math.Min(lo, canidate.Score)
+		hi = math.Max(hi, canidate.Score)
+	}
+
+	weights := make([]float64, n)
+	var total float64
+	for i, canidate := range canidates[:n] {
+		weights[i] = 1 + WEIGHTED_RANDOM_FLOOR
+		if hi > lo {
+			weights[i] = (hi-canidate.Score)/(hi-lo) + WEIGHTED_RANDOM_FLOOR
+		}
+		total += weights[i]
+	}
+
+	r := s.rand.Float64() * total
+	for i, weight := range weights {
+		r -= weight
+		if r < 0 {
+			return i
+		}
+	}
+	return n - 1
+}
+
+func (s *WeightedRandomStrategy) String() string {
+	return "weighted_random"
+}
+
+// Stick with one of the best $TopN canidates for $Period or $Queries
+// searches, whichever comes first, then move on to the next. Either limit
+// can be 0 to disable it.
+type RotationStrategy struct {
+	TopN    int
+	Period  time.Duration
+	Queries int
+
+	current string
+	since   time.Time
+	count   int
+}
+
+func (s *RotationStrategy) Select(canidates []*updater.Canidate, now time.Time) int {
+	n := topN(canidates, s.TopN)
+
+	i := -1
+	for j := 0; j < n; j++ {
+		if canidates[j].Url == s.current {
+			i = j
+			break
+		}
+	}
+
+	expired := (s.Period > 0 && now.Sub(s.since) >= s.Period) ||
+		(s.Queries > 0 && s.count >= s.Queries)
+	if i < 0 || expired {
+
+		// The next canidate after the current one, or the best one if the
+		// current one dropped out of the top N
+		i = (i + 1) % n
+		s.current = canidates[i].Url
+		s.since = now
+		s.count = 0
+	}
+
+	s.count++
+	return i
+}
+
+func (s *RotationStrategy) String() string {
+	return "rotation"
+}
+
+// How many canidates a strategy can choose from
+func topN(canidates []*updater.Canidate, n int) int {
+	if n <= 0 || n > len(canidates) {
+		return len(canidates)
+	}
+	return n
+}
+
+func NewSelectionStrategy(kind string, topN int, period time.Duration, queries int) (SelectionStrategy, error) {
+	switch strings.ToLower(kind) {
+	case "best":
+		return &BestStrategy{}, nil
+	case "round_robin":
+		return &RoundRobinStrategy{TopN: topN}, nil
+	case "weighted_random":
+		return &WeightedRandomStrategy{
+			TopN: topN,
+			rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+		}, nil
+	case "rotation":
+		return &RotationStrategy{
+			TopN:    topN,
+			Period:  period,
+			Queries: queries,
+		}, nil
+	default:
+		return nil, &ErrUnknownStrategy{kind}
+	}
+}
+
+// Get the strategy set in instx.yaml
+func getSelectionStrategy() SelectionStrategy {
+	conf := config.ParseConfig().Proxy.Selection
+
+	strategy, err := NewSelectionStrategy(
+		conf.Strategy,
+		conf.TopN,
+		time.Duration(conf.RotateMinutes)*time.Minute,
+		conf.RotateQueries)
+	if err != nil {
+		log.Printf("%s, falling back to best\n", err.Error())
+		strategy = &BestStrategy{}
+	}
+
+	return strategy
+}
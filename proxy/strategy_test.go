@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"gitlab.com/Njinx/instx/updater"
+)
+
+func newTestCanidates(urls ...string) []*updater.Canidate {
+	var ret []*updater.Canidate
+	for i, url := range urls {
+		ret = append(ret, &updater.Canidate{
+			Instance: updater.Instance{Url: url},
+			Score:    float64(i + 1),
+		})
+	}
+	return ret
+}
+
+func TestRoundRobinStrategy(t *testing.T) {
+	canidates := newTestCanidates("a", "b", "c", "d")
+	strategy := RoundRobinStrategy{TopN: 3}
+
+	want := []int{0, 1, 2, 0, 1}
+	for i, w := range want {
+		if got := strategy.Select(canidates, time.Time{}); got != w {
+			t.Errorf("selection %d = %d, want %d", i, got, w)
+		}
+	}
+
+	// Fewer healthy canidates than TopN
+	if got := strategy.Select(canidates[:1], time.Time{}); got != 0 {
+		t.Errorf("selection = %d, want 0", got)
+	}
+}
+
+func TestRotationStrategy(t *testing.T) {
+	canidates := newTestCanidates("a", "b", "c")
+	strategy := RotationStrategy{TopN: 2, Period: time.Minute, Queries: 3}
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if got := strategy.Select(canidates, now); got != 0 {
+			t.Fatalf("query %d went to %d, want 0", i, got)
+		}
+	}
+	if got := strategy.Select(canidates, now); got != 1 {
+		t.Errorf("rotated to %d after 3 queries, want 1", got)
+	}
+	if got := strategy.Select(canidates, now.Add(time.Minute)); got != 0 {
+		t.Errorf("rotated to %d after a minute, want 0", got)
+	}
+
+	// The current canidate went unhealthy
+	if got := strategy.Select(canidates[1:], now.Add(time.Minute)); canidates[1:][got].Url != "b" {
+		t.Errorf("switched to %s, want b", canidates[1:][got].Url)
+	}
+}
+
+func TestWeightedRandomStrategy(t *testing.T) {
+	canidates := newTestCanidates("a", "b", "c", "d")
+	canidates[0].Score = 0
+	strategy := WeightedRandomStrategy{TopN: 3, rand: rand.New(rand.NewSource(1))}
+
+	const n = 60000
+	counts := make([]int, len(canidates))
+	for i := 0; i < n; i++ {
+		counts[strategy.Select(canidates, time.Time{})]++
+	}
+
+	// Scores of 0, 2 and 3 give 1.1, 0.43 and 0.1 shares between the top 3.
+	// A score of 0 doesn't take every search.
+	total := 1.1 + 1.0/3.0 + 0.1 + 0.1
+	for i, want := range []float64{1.1 / total, (1.0/3.0 + 0.1) / total, 0.1 / total, 0} {
+		if got := float64(counts[i]) / n; math.Abs(got-want) > 0.01 {
+			t.Errorf("canidate %d got %.3f of searches, want %.3f", i, got, want)
+		}
+	}
+
+	// Equal scores get equal shares
+	for _, canidate := range canidates {
+		canidate.Score = 0.5
+	}
+	counts = make([]int, len(canidates))
+	for i := 0; i < n; i++ {
+		counts[strategy.Select(canidates, time.Time{})]++
+	}
+	for i, want := range []float64{1.0 / 3.0, 1.0 / 3.0, 1.0 / 3.0, 0} {
+		if got := float64(counts[i]) / n; math.Abs(got-want) > 0.01 {
+			t.Errorf("canidate %d got %.3f of searches, want %.3f", i, got, want)
+		}
+	}
+}
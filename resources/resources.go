@@ -13,6 +13,7 @@ var pages = map[string]string{
 	"/opensearch.xml": "root/opensearch.xml",
 	"/getstarted":     "root/getstarted.html",
 	"/favicon.ico":    "root/favicon.ico",
+	"/fallback":       "root/fallback.gohtml",
 }
 
 type VFS struct {
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"io"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -46,3 +47,70 @@ func TestGetFile(t *testing.T) {
 			realFSSha, vfsSha)
 	}
 }
+
+func TestFallbackTemplate(t *testing.T) {
+	vfs := New()
+
+	tmpl, err := vfs.GetFile("/fallback")
+	if err != nil {
+		t.Fatalf("could not retrieve \"/fallback\": %s", err.Error())
+	}
+
+	type link struct {
+		Url    string
+		Target string
+	}
+	data := struct {
+		Links     []link
+		LinksJson string
+		Timeout   int64
+	}{
+		Links:     []link{{"https://a.example/", "https://a.example/search?q=<b>&x=1"}},
+		LinksJson: `[]`,
+		Timeout:   5000,
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		t.Fatalf("could not execute template: %s", err.Error())
+	}
+
+	if bytes.Contains(buf.Bytes(), []byte("<b>")) {
+		t.Error("links weren't escaped")
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("q=&lt;b&gt;&amp;x=1")) {
+		t.Error("escaped link is missing")
+	}
+}
+
+func TestOpenSearchTemplate(t *testing.T) {
+	vfs := New()
+
+	tmpl, err := vfs.GetFile("/opensearch.xml")
+	if err != nil {
+		t.Fatalf("could not retrieve \"/opensearch.xml\": %s", err.Error())
+	}
+
+	render := func(suggestions bool) string {
+		data := struct {
+			Name        string
+			BaseUrl     string
+			Suggestions bool
+		}{"InstX", "http://search.lan:9000", suggestions}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			t.Fatalf("could not execute template: %s", err.Error())
+		}
+		return buf.String()
+	}
+
+	if got := render(true); !strings.Contains(got, `template="http://search.lan:9000/search?q={searchTerms}"`) {
+		t.Errorf("results URL doesn't use the base URL:\n%s", got)
+	} else if !strings.Contains(got, "/autocompleter") {
+		t.Errorf("suggestions URL is missing:\n%s", got)
+	}
+	if got := render(false); strings.Contains(got, "/autocompleter") {
+		t.Errorf("suggestions URL shouldn't be advertised:\n%s", got)
+	}
+}
@@ -0,0 +1,84 @@
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gitlab.com/Njinx/instx/util"
+)
+
+// The last good response from a remote source along with what's needed to
+// revalidate it.
+type sourceCache struct {
+	Url          string    `json:"url"`
+	ETag         string    `json:"etag"`
+	LastModified string    `json:"last_modified"`
+	Fetched      time.Time `json:"fetched"`
+
+	body []byte
+}
+
+// Get the cache file paths for $url. Each remote source gets its own pair of
+// files so that mirrors don't clobber each other.
+func getSourceCachePaths(url string) (string, string, error) {
+	cacheDir, err := util.GetCacheDir()
+	if err != nil {
+		return "", "", err
+	}
+
+	hash := sha256.Sum256([]byte(url))
+	name := fmt.Sprintf("instances-%x", hash[:8])
+	return filepath.Join(cacheDir, name+".json"), filepath.Join(cacheDir, name+".meta.json"), nil
+}
+
+// Read the cached response for $url. Returns nil if nothing has been cached.
+func loadSourceCache(url string) (*sourceCache, error) {
+	bodyPath, metaPath, err := getSourceCachePaths(url)
+	if err != nil {
+		return nil, err
+	}
+
+	metaData, err := os.ReadFile(metaPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var cache sourceCache
+	if err := json.Unmarshal(metaData, &cache); err != nil {
+		return nil, err
+	}
+
+	cache.body, err = os.ReadFile(bodyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &cache, nil
+}
+
+func (c *sourceCache) save() error {
+	bodyPath, metaPath, err := getSourceCachePaths(c.Url)
+	if err != nil {
+		return err
+	}
+
+	metaData, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	// Write the body first so the metadata never refers to a body we don't have
+	if err := os.WriteFile(bodyPath, c.body, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(metaPath, metaData, 0644)
+}
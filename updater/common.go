@@ -3,17 +3,10 @@ package updater
 import (
 	"container/list"
 	"fmt"
-	"io"
 	"log"
-	"math"
-	"net/http"
-	urllib "net/url"
-	"runtime"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/go-ping/ping"
 	"github.com/valyala/fastjson"
 	"gitlab.com/Njinx/instx/config"
 )
@@ -36,28 +29,73 @@ func schoolScaleToInt(grade string) int {
 	}
 }
 
-// Instance response times as specified here: https://searx.space#help-responsetime.
-// "Timings" is synonymous with "Latency" in this project. Not sure why
-// I picked the former.
-type Timings struct {
-	Initial   float64 `json:"initial"`
-	Search    float64 `json:"search"`
-	Google    float64 `json:"google"`
-	Wikipedia float64 `json:"wikipedia"`
+// A percentage, or not Ok if searx.space didn't publish it
+type Percentage struct {
+	Value float64 `json:"value"`
+	Ok    bool    `json:"ok"`
 }
 
-func (s *Timings) String() string {
-	return fmt.Sprintf("( I=%.02f, S=%.02f, G=%.02f, W=%.02f )",
-		s.Initial,
-		s.Search,
-		s.Google,
-		s.Wikipedia)
+// Uptime percentages over the last day, week and month. Each is known or
+// unknown on its own; new instances don't have a month yet.
+type Uptime struct {
+	Day   Percentage `json:"day"`
+	Week  Percentage `json:"week"`
+	Month Percentage `json:"month"`
+}
+
+// Get the uptime over $period, which is "day", "week" or "month"
+func (u *Uptime) get(period string) (float64, bool) {
+	var ret Percentage
+	switch strings.ToLower(period) {
+	case "day":
+		ret = u.Day
+	case "week":
+		ret = u.Week
+	default:
+		ret = u.Month
+	}
+	return ret.Value, ret.Ok
+}
+
+// Read a percentage from searx.space's data. Missing and null values
+// aren't Ok.
+func readPercentage(v *fastjson.Value, keys ...string) Percentage {
+	value := v.Get(keys...)
+	if value == nil || value.Type() != fastjson.TypeNumber {
+		return Percentage{}
+	}
+	return Percentage{value.GetFloat64(), true}
 }
 
 // TODO: Merge Instance(s) and Canidate(s) structs
 type Instance struct {
-	Url     string  `json:"url"`
-	Timings Timings `json:"timings"`
+	Url      string  `json:"url"`
+	Timings  Timings `json:"timings"`
+	CspGrade string  `json:"csp_grade"`
+	TlsGrade string  `json:"tls_grade"`
+	Uptime   Uptime  `json:"uptime"`
+	Version  string  `json:"version"`
+	GitUrl   string  `json:"git_url"`
+
+	// Checked against the criteria of each profile
+	HttpGrade string `json:"http_grade"`
+	Analytics bool   `json:"analytics"`
+	IsOnion   bool   `json:"is_onion"`
+	Dnssec    int    `json:"dnssec"`
+	Generator string `json:"generator"`
+
+	// Where and by whom the instance is hosted, one for each network its
+	// IPs are on. Empty if unknown.
+	Networks []Network `json:"networks"`
+
+	// Reachable over IPv6
+	Ipv6 bool `json:"ipv6"`
+
+	// Engines the instance has enabled, if the source lists them
+	Engines []string `json:"engines,omitempty"`
+
+	// Listed by a static source rather than a searx.space-compatible one
+	Static bool `json:"static"`
 }
 
 func (s *Instance) String() string {
@@ -66,52 +104,65 @@ func (s *Instance) String() string {
 
 type Instances struct {
 	instanceList []Instance
+
+	// Instances that can't be used no matter the criteria, and why
+	rejected []Rejection
+
+	// At least one source couldn't be reached and its cached copy was used
+	stale bool
 }
 
-func NewInstances(instancesUrl string) Instances {
-	ret, err := parseSearxSpaceResponse(instancesUrl)
-	if err != nil {
-		log.Fatalf(err.Error())
+func NewInstances(sources []Source) (Instances, error) {
+	return fetchInstances(sources, config.ParseConfig().Updater.InstanceBlacklist)
+}
+
+// Get every published value of one response time
+func (s *Instances) getTimingSamples(get func(timings *Timings) Timing) []float64 {
+	var ret []float64
+	for i := range s.instanceList {
+		if timing := get(&s.instanceList[i].Timings); timing.Ok {
+			ret = append(ret, timing.Value)
+		}
 	}
 	return ret
 }
 
-// TODO: Remove outliers from calculations
-// Get the average latency for all instances
-func (s *Instances) getTimingAvgs() Timings {
-	avgs := Timings{0.0, 0.0, 0.0, 0.0}
-
-	var initialI float64
-	var searchI float64
-	var googleI float64
-	var wikipediaI float64
-
-	for _, inst := range s.instanceList {
-		timings := inst.Timings
-		if timings.Initial > 0 {
-			avgs.Initial += timings.Initial
-			initialI++
-		}
-		if timings.Search > 0 {
-			avgs.Search += timings.Search
-			searchI++
-		}
-		if timings.Google > 0 {
-			avgs.Google += timings.Google
-			googleI++
-		}
-		if timings.Wikipedia > 0 {
-			avgs.Wikipedia += timings.Wikipedia
-			wikipediaI++
+// Combine the published values of each response time with $combine.
+// Timings that weren't published aren't counted.
+func (s *Instances) combineTimings(combine func(samples []float64) float64) Timings {
+	get := func(get func(timings *Timings) Timing) Timing {
+		samples := s.getTimingSamples(get)
+
+		// Static sources don't have any timings, so there may be nothing to combine
+		if len(samples) == 0 {
+			return Timing{}
 		}
+		return Timing{combine(samples), true}
+	}
+
+	return Timings{
+		Initial:   get(func(timings *Timings) Timing { return timings.Initial }),
+		Search:    get(func(timings *Timings) Timing { return timings.Search }),
+		Google:    get(func(timings *Timings) Timing { return timings.Google }),
+		Wikipedia: get(func(timings *Timings) Timing { return timings.Wikipedia }),
 	}
+}
 
-	avgs.Initial /= initialI
-	avgs.Search /= searchI
-	avgs.Google /= googleI
-	avgs.Wikipedia /= wikipediaI
+// Get the average latency for all instances
+func (s *Instances) getTimingAvgs() Timings {
+	return s.combineTimings(func(samples []float64) float64 {
+		var sum float64
+		for _, sample := range samples {
+			sum += sample
+		}
+		return sum / float64(len(samples))
+	})
+}
 
-	return avgs
+// Get the median latency for all instances. Unlike the average, it isn't
+// dragged up by a few very slow instances.
+func (s *Instances) getTimingMedians() Timings {
+	return s.combineTimings(median)
 }
 
 func (s *Instances) String() string {
@@ -127,62 +178,40 @@ func (s *Instances) String() string {
 	return fmt.Sprintf("{\n%s\n}", strings.Join(getStrings(s.instanceList), ",\n"))
 }
 
-var instances Instances
-
-// Get instances data from https://searx.space
-func parseSearxSpaceResponse(url string) (Instances, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return Instances{}, err
-	}
-	defer resp.Body.Close()
-
-	jsonResp, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return Instances{}, err
-	}
+// Parse instances data in the format served by https://searx.space
+func parseInstancesJson(jsonResp []byte) (Instances, error) {
 
 	// Since our JSON is irregular (URLs being used as keys) we can't marshal it
 	var parser fastjson.Parser
-	jsonData, err := parser.Parse(string(jsonResp))
+	jsonData, err := parser.ParseBytes(jsonResp)
 	if err != nil {
 		return Instances{}, err
 	}
 
-	jsonData.GetObject("instances").Visit(visitInstance)
+	asns := parseAsnTable(jsonData)
+
+	var instances Instances
+	jsonData.GetObject("instances").Visit(func(k []byte, v *fastjson.Value) {
+		if inst, reason := visitInstance(k, v, &asns); len(reason) == 0 {
+			instances.instanceList = append(instances.instanceList, inst)
+		} else {
+			instances.rejected = append(instances.rejected, Rejection{string(k), reason})
+		}
+	})
 	return instances, nil
 }
 
-// For each instance in searx.space response JSON...
-func visitInstance(k []byte, v *fastjson.Value) {
+// For each instance in searx.space response JSON... Returns why the instance
+// can't be used at all, or an empty string if it can.
+func visitInstance(k []byte, v *fastjson.Value, asns *asnTable) (Instance, string) {
 
 	// If latency data doesn't exist, just give up ffs
 	if !v.Exists("timing") {
-		return
+		return Instance{}, "No timing data"
 	}
 
 	instUrl := string(k)
 
-	c := config.ParseConfig()
-	criteria := c.Updater.Criteria
-
-	// Check if instance is in the blacklist
-	for _, blisted := range c.Updater.InstanceBlacklist {
-		instUrlParsed, err := urllib.Parse(instUrl)
-		if err != nil {
-			log.Printf("Could not parse URL \"%s\": %s\n", instUrlParsed, err.Error())
-			break
-		}
-		blistUrlParsed, err := urllib.Parse(blisted)
-		if err != nil {
-			log.Printf("Could not parse URL \"%s\": %s\n", blistUrlParsed, err.Error())
-		}
-
-		if instUrlParsed.Host == blistUrlParsed.Host {
-			return
-		}
-	}
-
 	cspGrade := string(v.GetStringBytes("http", "grade")[:])
 	if cspGrade == "" {
 		cspGrade = "F"
@@ -191,102 +220,122 @@ func visitInstance(k []byte, v *fastjson.Value) {
 	if tlsGrade == "" {
 		tlsGrade = "F"
 	}
-	httpGrade := strings.ToLower(string(v.GetStringBytes("html", "grade")[:]))
-	if httpGrade == "" {
-		httpGrade = ""
-	}
-	hasAnalytics := v.GetBool("analytics")
-	isOnion := bool(strings.ToLower(string(v.GetStringBytes("network_type")[:])) == "tor")
-	hasDnssec := v.GetInt("network", "dnssec")
-	searxFork := strings.ToLower(string(v.GetStringBytes("generator")[:]))
 
-	if schoolScaleToInt(cspGrade) < schoolScaleToInt(criteria.MinimumCspGrade) {
-		return
-	}
-	if schoolScaleToInt(tlsGrade) < schoolScaleToInt(criteria.MinimumTlsGrade) {
-		return
-	}
-	hasAllowedHttpGrade := false
-	for _, curGrade := range criteria.AllowedHttpGrades {
-		if strings.ToLower(curGrade) == httpGrade {
-			hasAllowedHttpGrade = true
-			break
-		}
-	}
-	if !hasAllowedHttpGrade {
-		return
-	}
-	if hasAnalytics && !criteria.AllowAnalytics {
-		return
-	}
-	if isOnion != criteria.IsOnion {
-		return
+	stats := config.ParseConfig().Updater.Advanced.TimingStatistics
+	timings := Timings{
+		Initial:   readTiming(v, "initial", stats.Initial),
+		Search:    readTiming(v, "search", stats.Search),
+		Google:    readTiming(v, "search_go", stats.Google),
+		Wikipedia: readTiming(v, "search_wp", stats.Wikipedia),
 	}
 
-	// According to the API, hasDnssec = 1 (Secure), hasDnssec = 2 (Insecure)
-	if hasDnssec != 1 && criteria.RequireDnssec {
-		return
-	}
-	if searxFork == "searx" && strings.ToLower(criteria.SearxngPreference) == "required" {
-		return
-	}
-	if searxFork == "searxng" && strings.ToLower(criteria.SearxngPreference) == "forbidden" {
-		return
+	// Partial timings are fine, but with none at all the instance is most
+	// likely down
+	if !timings.Initial.Ok && !timings.Search.Ok && !timings.Google.Ok && !timings.Wikipedia.Ok {
+		return Instance{}, "No response times"
 	}
 
-	negativeOneOnError := func(n float64) float64 {
-		if math.Abs(n) <= math.Nextafter(1.0, 2.0)-1.0 {
-			return -1.0
-		} else {
-			return n
-		}
-	}
+	var engines []string
+	v.GetObject("engines").Visit(func(k []byte, _ *fastjson.Value) {
+		engines = append(engines, strings.ToLower(string(k)))
+	})
 
-	// TODO: Sometimes latency isn't provided, so account for that
-	timings := Timings{
-		Initial: float64(negativeOneOnError(
-			v.GetFloat64("timing", "initial", "all", "value"))),
-		Search: float64(negativeOneOnError(
-			v.GetFloat64("timing", "search", "all", "median"))),
-		Google: float64(negativeOneOnError(
-			v.GetFloat64("timing", "search", "all", "median"))),
-		Wikipedia: float64(negativeOneOnError(
-			v.GetFloat64("timing", "search", "all", "median"))),
+	uptime := Uptime{
+		Day:   readPercentage(v, "uptime", "uptimeDay"),
+		Week:  readPercentage(v, "uptime", "uptimeWeek"),
+		Month: readPercentage(v, "uptime", "uptimeMonth"),
 	}
 
-	instances.instanceList = append(instances.instanceList, Instance{
-		Url:     instUrl,
-		Timings: timings,
-	})
+	return Instance{
+		Url:      instUrl,
+		Timings:  timings,
+		CspGrade: cspGrade,
+		TlsGrade: tlsGrade,
+		Uptime:   uptime,
+		Version:  string(v.GetStringBytes("version")),
+		GitUrl:   string(v.GetStringBytes("git_url")),
+
+		HttpGrade: strings.ToLower(string(v.GetStringBytes("html", "grade"))),
+		Analytics: v.GetBool("analytics"),
+		IsOnion:   strings.ToLower(string(v.GetStringBytes("network_type"))) == "tor",
+		Dnssec:    v.GetInt("network", "dnssec"),
+		Generator: strings.ToLower(string(v.GetStringBytes("generator"))),
+
+		Networks: asns.getNetworks(v),
+		Ipv6:     v.GetBool("network", "ipv6"),
+		Engines:  engines,
+	}, ""
 }
 
 type Canidate struct {
-	Instance  `json:"instance"`
-	Score     float64 `json:"score"`
-	IsCurrent bool    `json:"is_current"`
+	Instance `json:"instance"`
+	Score    float64 `json:"score"`
+
+	// searx.space's response times blended with our latency tests, in seconds
+	ResponseTime float64 `json:"response_time"`
+
+	// Time-decayed share of updates the instance was up for, from 0 to 1
+	Reliability float64 `json:"reliability"`
+
+	IsCurrent   bool               `json:"is_current"`
+	Latency     *LatencyResponse   `json:"latency,omitempty"`
+	SearchProbe *SearchProbeResult `json:"search_probe,omitempty"`
+
+	// Set by the proxy's health check. The canidate is skipped until then.
+	UnhealthyUntil time.Time `json:"unhealthy_until"`
+
+	// A user said the instance was bad. It's scored worse until then.
+	PenalizedUntil time.Time `json:"penalized_until"`
+}
+
+// Whether the health check has taken the canidate out of rotation
+func (s *Canidate) IsHealthy(now time.Time) bool {
+	return !now.Before(s.UnhealthyUntil)
 }
 
 func (s *Canidate) String() string {
 	return fmt.Sprintf("[%0.2f] %s", s.Score, s.Instance.String())
 }
 
+// Why an instance didn't make it into the canidates
+type Rejection struct {
+	Url    string `json:"url"`
+	Reason string `json:"reason"`
+}
+
 type Canidates struct {
 	*list.List
+
+	// Instances that were turned down and why
+	Rejected []Rejection
+
+	// Ranked using cached instance data because a source couldn't be reached
+	Stale bool
+
+	// When the canidates were ranked
+	Updated time.Time
 }
 
 func NewCanidates() Canidates {
 	return Canidates{
-		list.New(),
+		List: list.New(),
 	}
 }
 
 // Canidates struct with primitive array type
 type CanidatesMarshalable struct {
-	List []Canidate `json:"canidates"`
+	List     []Canidate  `json:"canidates"`
+	Rejected []Rejection `json:"rejected"`
+	Stale    bool        `json:"stale"`
+	Updated  time.Time   `json:"updated"`
 }
 
 func NewCanidatesMarshalable(canidates *Canidates) CanidatesMarshalable {
-	var marshalable CanidatesMarshalable
+	marshalable := CanidatesMarshalable{
+		Rejected: canidates.Rejected,
+		Stale:    canidates.Stale,
+		Updated:  canidates.Updated,
+	}
 
 	for canidate := canidates.Front(); canidate != nil; canidate = canidate.Next() {
 		val, ok := canidate.Value.(Canidate)
@@ -301,6 +350,41 @@ func NewCanidatesMarshalable(canidates *Canidates) CanidatesMarshalable {
 	return marshalable
 }
 
+func NewCanidatesFromMarshalable(marshalable *CanidatesMarshalable) Canidates {
+	canidates := NewCanidates()
+	canidates.Rejected = marshalable.Rejected
+	canidates.Stale = marshalable.Stale
+	canidates.Updated = marshalable.Updated
+
+	for _, canidate := range marshalable.List {
+		canidates.PushBack(canidate)
+	}
+
+	return canidates
+}
+
+// Ranked canidates for each profile, by name. config.DEFAULT_PROFILE is
+// always there.
+type ProfileCanidates map[string]*Canidates
+
+// Empty canidates for every profile in instx.yaml
+func NewProfileCanidates() ProfileCanidates {
+	c := config.ParseConfig()
+	profiles := make(ProfileCanidates)
+	for _, name := range c.ProfileNames() {
+		canidates := NewCanidates()
+		profiles[name] = &canidates
+	}
+	return profiles
+}
+
+// Record that the instance at $url was turned down. Rejections aren't logged
+// one by one since there are hundreds of them for every profile; see
+// `instxctl rejected`.
+func (c *Canidates) Reject(url string, reason string) {
+	c.Rejected = append(c.Rejected, Rejection{url, reason})
+}
+
 // Iterate over canidates
 func (c *Canidates) Iterate(fn func(canidate *Canidate) bool) {
 	for elem := c.Front(); elem != nil; elem = elem.Next() {
@@ -358,6 +442,20 @@ func (c *Canidates) Sort() {
 	}
 }
 
+// Move every canidate matching $fn to the back, keeping their order
+func (c *Canidates) MoveToBackIf(fn func(canidate *Canidate) bool) {
+	var matches []*list.Element
+	for elem := c.Front(); elem != nil; elem = elem.Next() {
+		if canidate, ok := elem.Value.(Canidate); ok && fn(&canidate) {
+			matches = append(matches, elem)
+		}
+	}
+
+	for _, elem := range matches {
+		c.MoveToBack(elem)
+	}
+}
+
 // Reverse the canidates list
 func (c *Canidates) Reverse() {
 	c.DoubleIterate(func(canidate1 *Canidate, canidate2 *Canidate) bool {
@@ -365,96 +463,3 @@ func (c *Canidates) Reverse() {
 		return false
 	})
 }
-
-type LatencyResponse struct {
-	hostname   string
-	avgLatency float64
-	isAlive    bool
-	packetLoss float64
-}
-
-// Helper function that conducts latency tests
-func doLatencyTestsEx(
-	urls []string,
-	count int,
-	interval time.Duration,
-	timeout time.Duration) []LatencyResponse {
-
-	var m sync.Mutex
-	var wg sync.WaitGroup
-	var ret []LatencyResponse
-
-	for _, tmpUrl := range urls {
-		wg.Add(1)
-
-		// TODO: Figure out if this clone is really necessary
-		url := strings.Clone(tmpUrl)
-
-		// Don't block during latency tests
-		go func() {
-			resp := LatencyResponse{
-				hostname: url,
-			}
-
-			parsedUrl, err := urllib.Parse(url)
-			if err != nil {
-				log.Printf("Could not parse URL \"%s\": %s", url, err.Error())
-				wg.Done()
-				return
-			}
-			hostname := parsedUrl.Host
-
-			pinger, err := ping.NewPinger(hostname)
-			if err != nil {
-				log.Println(err.Error())
-				resp.isAlive = false
-				return
-			}
-
-			pinger.Count = count
-			pinger.Interval = interval
-			pinger.Timeout = timeout
-
-			// See: https://github.com/go-ping/ping#windows
-			if runtime.GOOS == "windows" {
-				pinger.SetPrivileged(true)
-			} else {
-				pinger.SetPrivileged(false)
-			}
-
-			err = pinger.Run()
-			if err != nil {
-				log.Printf("Could not ping \"%s\": %s\n", hostname, err.Error())
-				resp.isAlive = false
-				return
-			}
-
-			stats := pinger.Statistics()
-			resp.avgLatency = stats.AvgRtt.Seconds()
-			resp.packetLoss = stats.PacketLoss
-
-			m.Lock()
-			ret = append(ret, resp)
-			m.Unlock()
-
-			wg.Done()
-		}()
-	}
-
-	wg.Wait()
-	return ret
-}
-
-// Basic latency test
-func doLatencyTests(urls []string) []LatencyResponse {
-
-	// 4 pings, 200ms apart, timeout after 1s
-	return doLatencyTestsEx(urls, 4, 200*time.Millisecond, 1*time.Second)
-}
-
-// More intensive latency test
-func doLatencyTestIntensive(url string) LatencyResponse {
-
-	// 8 pings, 2s apart, timeout after 4s
-	return doLatencyTestsEx([]string{url}, 8, 2*time.Second, 4*time.Second)[0]
-}
@@ -0,0 +1,213 @@
+package updater
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"gitlab.com/Njinx/instx/config"
+)
+
+// Check $inst against $criteria at $now. Returns why it doesn't meet them, or
+// false if it does. Static instances have no searx.space data, so they always
+// do.
+func checkCriteria(inst *Instance, criteria *config.CriteriaConfig, now time.Time) (string, bool) {
+	if inst.Static {
+		return "", false
+	}
+
+	if schoolScaleToInt(inst.CspGrade) < schoolScaleToInt(criteria.MinimumCspGrade) {
+		return fmt.Sprintf("CSP grade %s is below %s", inst.CspGrade, criteria.MinimumCspGrade), true
+	}
+	if schoolScaleToInt(inst.TlsGrade) < schoolScaleToInt(criteria.MinimumTlsGrade) {
+		return fmt.Sprintf("TLS grade %s is below %s", inst.TlsGrade, criteria.MinimumTlsGrade), true
+	}
+
+	hasAllowedHttpGrade := false
+	for _, curGrade := range criteria.AllowedHttpGrades {
+		if strings.ToLower(curGrade) == inst.HttpGrade {
+			hasAllowedHttpGrade = true
+			break
+		}
+	}
+	if !hasAllowedHttpGrade {
+		return fmt.Sprintf("HTTP grade \"%s\" isn't allowed", inst.HttpGrade), true
+	}
+
+	if inst.Analytics && !criteria.AllowAnalytics {
+		return "Uses analytics", true
+	}
+	if inst.IsOnion != criteria.IsOnion {
+		if inst.IsOnion {
+			return "Is an onion service", true
+		}
+		return "Isn't an onion service", true
+	}
+
+	// According to the API, Dnssec = 1 (Secure), Dnssec = 2 (Insecure)
+	if inst.Dnssec != 1 && criteria.RequireDnssec {
+		return "No DNSSEC", true
+	}
+	if inst.Generator == "searx" && strings.ToLower(criteria.SearxngPreference) == "required" {
+		return "Isn't SearXNG", true
+	}
+	if inst.Generator == "searxng" && strings.ToLower(criteria.SearxngPreference) == "forbidden" {
+		return "Is SearXNG", true
+	}
+
+	// Instances on several networks are only allowed if all of them are, and
+	// denied if any of them is
+	if len(criteria.AllowedCountries) > 0 {
+		if len(inst.Networks) == 0 {
+			return "Country is unknown", true
+		}
+		for _, network := range inst.Networks {
+			if len(network.Country) == 0 {
+				return "Country is unknown", true
+			} else if !containsFold(criteria.AllowedCountries, network.Country) {
+				return fmt.Sprintf("Hosted in %s, which isn't allowed", network.Country), true
+			}
+		}
+	}
+	for _, network := range inst.Networks {
+		if len(network.Country) > 0 && containsFold(criteria.DeniedCountries, network.Country) {
+			return fmt.Sprintf("Hosted in %s, which is denied", network.Country), true
+		}
+	}
+
+	if len(criteria.AllowedNetworks) > 0 {
+		if len(inst.Networks) == 0 {
+			return "Network is unknown", true
+		}
+		for _, network := range inst.Networks {
+			if len(network.Asn) == 0 {
+				return "Network is unknown", true
+			} else if !matchesAnyNetwork(&network, criteria.AllowedNetworks) {
+				return fmt.Sprintf("Hosted on %s, which isn't allowed", network.String()), true
+			}
+		}
+	}
+	for _, network := range inst.Networks {
+		if matchesAnyNetwork(&network, criteria.DeniedNetworks) {
+			return fmt.Sprintf("Hosted on %s, which is denied", network.String()), true
+		}
+	}
+
+	if criteria.RequireIpv6 && !inst.Ipv6 {
+		return "Not reachable over IPv6", true
+	}
+
+	if reason, rejected := checkVersion(inst, criteria, now); rejected {
+		return reason, true
+	}
+
+	for _, minimum := range []struct {
+		period  string
+		percent float64
+	}{
+		{"day", criteria.MinimumUptimeDay},
+		{"week", criteria.MinimumUptimeWeek},
+		{"month", criteria.MinimumUptimeMonth},
+	} {
+		if minimum.percent <= 0 {
+			continue
+		}
+
+		uptime, ok := inst.Uptime.get(minimum.period)
+		if !ok {
+			return "Uptime is unknown", true
+		} else if uptime < minimum.percent {
+			return fmt.Sprintf("Up %.1f%% of the last %s, below %g%%", uptime, minimum.period, minimum.percent), true
+		}
+	}
+
+	if criteria.RequireUpstream {
+		gitUrl := normalizeGitUrl(inst.GitUrl)
+		upstream := false
+		for _, url := range criteria.UpstreamGitUrls {
+			if normalizeGitUrl(url) == gitUrl {
+				upstream = true
+				break
+			}
+		}
+
+		if len(gitUrl) == 0 {
+			return "Source is unknown", true
+		} else if !upstream {
+			return fmt.Sprintf("Runs a fork (%s)", inst.GitUrl), true
+		} else if isDirtyVersion(inst.Version) {
+			return fmt.Sprintf("Runs modified code (%s)", inst.Version), true
+		}
+	}
+
+	return "", false
+}
+
+// Check the version of $inst against the minimum versions and maximum age in
+// $criteria
+func checkVersion(inst *Instance, criteria *config.CriteriaConfig, now time.Time) (string, bool) {
+	date, dated := parseVersionDate(inst.Version)
+
+	// SearXNG's versions are dates, SearX's aren't
+	isSearxng := inst.Generator == "searxng" || (inst.Generator == "" && dated)
+	minimum := criteria.MinimumSearx
+	if isSearxng {
+		minimum = criteria.MinimumSearxng
+	}
+
+	if len(minimum) > 0 {
+		var older, ok bool
+		if isSearxng {
+			minimumDate, minimumOk := parseVersionDate(minimum)
+			older, ok = date.Before(minimumDate), dated && minimumOk
+		} else {
+			cmp, cmpOk := compareSemver(inst.Version, minimum)
+			older, ok = cmp < 0, cmpOk
+		}
+
+		if !ok {
+			return fmt.Sprintf("Version \"%s\" is unknown", inst.Version), true
+		} else if older {
+			return fmt.Sprintf("Version %s is older than %s", inst.Version, minimum), true
+		}
+	}
+
+	// Only SearXNG versions say how old they are
+	maxAge := time.Duration(criteria.MaxVersionAge) * 24 * time.Hour
+	if maxAge > 0 && dated && now.Sub(date) > maxAge {
+		return fmt.Sprintf("Version %s is more than %d days old", inst.Version, criteria.MaxVersionAge), true
+	}
+
+	return "", false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+var asnRegex = regexp.MustCompile(`^(?i:AS)?[0-9]+$`)
+
+// Whether $network is any of $networks. Networks are either ASNs, e.g.
+// "AS13335", or part of the provider's name, e.g. "cloudflare".
+func matchesAnyNetwork(network *Network, networks []string) bool {
+	if len(network.Asn) == 0 {
+		return false
+	}
+
+	for _, other := range networks {
+		if asnRegex.MatchString(other) {
+			if normalizeAsn(other) == network.Asn {
+				return true
+			}
+		} else if len(other) > 0 && strings.Contains(strings.ToLower(network.Provider), strings.ToLower(other)) {
+			return true
+		}
+	}
+	return false
+}
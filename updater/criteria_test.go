@@ -0,0 +1,162 @@
+package updater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fastjson"
+	"gitlab.com/Njinx/instx/config"
+)
+
+func TestCheckCriteria(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	criteria := config.CriteriaConfig{
+		MinimumCspGrade:   "A",
+		MinimumTlsGrade:   "A",
+		AllowedHttpGrades: []string{"V", "F", "C"},
+		RequireDnssec:     true,
+		SearxngPreference: "required",
+		DeniedCountries:   []string{"us"},
+		DeniedNetworks:    []string{"cloudflare", "AS16509"},
+		RequireIpv6:       true,
+		MinimumSearxng:    "2023.6.1",
+		MaxVersionAge:     180,
+		RequireUpstream:   true,
+		UpstreamGitUrls:   []string{"https://github.com/searxng/searxng"},
+		MinimumUptimeWeek: 95,
+	}
+	good := Instance{
+		CspGrade:  "A+",
+		TlsGrade:  "A",
+		HttpGrade: "v",
+		Dnssec:    1,
+		Generator: "searxng",
+		Networks:  []Network{{Asn: "AS24940", Country: "DE", Provider: "HETZNER-AS"}},
+		Ipv6:      true,
+		Version:   "2023.11.1+abc",
+		GitUrl:    "https://GitHub.com/searxng/searxng.git",
+		Uptime:    Uptime{Day: Percentage{100, true}, Week: Percentage{99.5, true}, Month: Percentage{90, true}},
+	}
+
+	if reason, rejected := checkCriteria(&good, &criteria, now); rejected {
+		t.Errorf("good instance was rejected: %s", reason)
+	}
+
+	tests := map[string]func(inst *Instance){
+		"csp grade":  func(inst *Instance) { inst.CspGrade = "B" },
+		"tls grade":  func(inst *Instance) { inst.TlsGrade = "F" },
+		"http grade": func(inst *Instance) { inst.HttpGrade = "cjs" },
+		"analytics":  func(inst *Instance) { inst.Analytics = true },
+		"onion":      func(inst *Instance) { inst.IsOnion = true },
+		"dnssec":     func(inst *Instance) { inst.Dnssec = 2 },
+		"generator":  func(inst *Instance) { inst.Generator = "searx" },
+		"country":    func(inst *Instance) { inst.Networks = []Network{{Asn: "AS24940", Country: "US"}} },
+		"provider":   func(inst *Instance) { inst.Networks = []Network{{Asn: "AS13335", Provider: "CLOUDFLARENET"}} },
+		"asn":        func(inst *Instance) { inst.Networks = []Network{{Asn: "AS16509"}} },
+		"any network": func(inst *Instance) {
+			inst.Networks = []Network{inst.Networks[0], {Asn: "AS13335", Country: "DE", Provider: "CLOUDFLARENET"}}
+		},
+		"ipv6":        func(inst *Instance) { inst.Ipv6 = false },
+		"min version": func(inst *Instance) { inst.Version = "2023.5.30+abc" },
+		"version age": func(inst *Instance) { inst.Version = "2023.6.2+abc" },
+		"no version":  func(inst *Instance) { inst.Version = "" },
+		"fork":        func(inst *Instance) { inst.GitUrl = "https://github.com/someone/searxng" },
+		"no git url":  func(inst *Instance) { inst.GitUrl = "" },
+		"dirty":       func(inst *Instance) { inst.Version = "2023.11.1+abc+dirty" },
+		"uptime":      func(inst *Instance) { inst.Uptime.Week.Value = 50 },
+		"no uptime":   func(inst *Instance) { inst.Uptime = Uptime{} },
+		"no week":     func(inst *Instance) { inst.Uptime.Week = Percentage{} },
+	}
+	for name, change := range tests {
+		inst := good
+		change(&inst)
+		if _, rejected := checkCriteria(&inst, &criteria, now); !rejected {
+			t.Errorf("%s: instance wasn't rejected", name)
+		}
+
+		inst.Static = true
+		if _, rejected := checkCriteria(&inst, &criteria, now); rejected {
+			t.Errorf("%s: static instance was rejected", name)
+		}
+	}
+}
+
+func TestCheckCriteriaAllowLists(t *testing.T) {
+	now := time.Now()
+	criteria := config.CriteriaConfig{
+		MinimumCspGrade:   "F",
+		MinimumTlsGrade:   "F",
+		AllowedHttpGrades: []string{""},
+		AllowedCountries:  []string{"DE", "NL"},
+		AllowedNetworks:   []string{"24940"},
+	}
+
+	tests := map[string]struct {
+		inst     Instance
+		rejected bool
+	}{
+		"allowed":         {Instance{Networks: []Network{{Country: "de", Asn: "AS24940"}}}, false},
+		"both allowed":    {Instance{Networks: []Network{{Country: "DE", Asn: "AS24940"}, {Country: "NL", Asn: "AS24940"}}}, false},
+		"no networks":     {Instance{}, true},
+		"unknown country": {Instance{Networks: []Network{{Asn: "AS24940"}}}, true},
+		"other country":   {Instance{Networks: []Network{{Country: "FR", Asn: "AS24940"}}}, true},
+		"unknown network": {Instance{Networks: []Network{{Country: "NL"}}}, true},
+		"other network":   {Instance{Networks: []Network{{Country: "NL", Asn: "AS16276"}}}, true},
+		"one not allowed": {Instance{Networks: []Network{{Country: "DE", Asn: "AS24940"}, {Country: "DE", Asn: "AS13335"}}}, true},
+	}
+	for name, test := range tests {
+		test.inst.CspGrade, test.inst.TlsGrade = "A", "A"
+		if reason, rejected := checkCriteria(&test.inst, &criteria, now); rejected != test.rejected {
+			t.Errorf("%s: rejected = %v (%s), want %v", name, rejected, reason, test.rejected)
+		}
+	}
+}
+
+func TestCheckSearxVersion(t *testing.T) {
+	criteria := config.CriteriaConfig{MinimumSearx: "1.1", MaxVersionAge: 30}
+
+	tests := map[string]bool{
+		"1.1.0-69-75b859d2": false,
+		"1.2":               false,
+		"1.0.0":             true,
+		"unknown":           true,
+	}
+	for version, want := range tests {
+		inst := Instance{Generator: "searx", Version: version}
+		if reason, rejected := checkVersion(&inst, &criteria, time.Now()); rejected != want {
+			t.Errorf("%s: rejected = %v (%s), want %v", version, rejected, reason, want)
+		}
+	}
+}
+
+func TestCheckUptime(t *testing.T) {
+	criteria := config.CriteriaConfig{
+		MinimumCspGrade:   "F",
+		MinimumTlsGrade:   "F",
+		AllowedHttpGrades: []string{""},
+		MinimumUptimeDay:  90,
+	}
+
+	// A missing or null day is unknown rather than 0%
+	v := fastjson.MustParse(`{"uptime": {"uptimeDay": null, "uptimeMonth": 99.9}}`)
+	inst := Instance{
+		CspGrade: "A",
+		TlsGrade: "A",
+		Uptime: Uptime{
+			Day:   readPercentage(v, "uptime", "uptimeDay"),
+			Week:  readPercentage(v, "uptime", "uptimeWeek"),
+			Month: readPercentage(v, "uptime", "uptimeMonth"),
+		},
+	}
+	if !inst.Uptime.Month.Ok || inst.Uptime.Day.Ok || inst.Uptime.Week.Ok {
+		t.Errorf("unexpected uptime %+v", inst.Uptime)
+	}
+	if reason, _ := checkCriteria(&inst, &criteria, time.Now()); reason != "Uptime is unknown" {
+		t.Errorf("reason = %s, want \"Uptime is unknown\"", reason)
+	}
+
+	inst.Uptime.Day = Percentage{95, true}
+	if reason, rejected := checkCriteria(&inst, &criteria, time.Now()); rejected {
+		t.Errorf("instance was rejected: %s", reason)
+	}
+}
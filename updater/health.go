@@ -0,0 +1,37 @@
+package updater
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// Check whether the instance at $url is still answering. This only loads the
+// front page, so it's cheap enough to run every minute or so.
+func CheckHealth(url string, timeout time.Duration) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", SEARCH_PROBE_USER_AGENT)
+	req.Header.Set("Accept", "text/html")
+
+	client := http.Client{
+		Timeout: timeout,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, SEARCH_PROBE_MAX_BODY))
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return &ErrUnexpectedStatus{url, resp.Status}
+	}
+
+	return nil
+}
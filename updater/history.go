@@ -0,0 +1,277 @@
+package updater
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/Njinx/instx/config"
+	"gitlab.com/Njinx/instx/util"
+)
+
+const HISTORY_FILE = "history.json"
+
+// The outcome of testing an instance during one update
+type HistorySample struct {
+	Time  time.Time `json:"time"`
+	Alive bool      `json:"alive"`
+
+	// Measured round-trip time in seconds. 0 if the instance was down.
+	Latency float64 `json:"latency"`
+
+	// Where the instance ended up in the ranking, starting at 1. 0 if it
+	// wasn't ranked.
+	Rank int `json:"rank"`
+}
+
+// Per-instance test outcomes over time, keyed by instance URL
+type History struct {
+	Instances map[string][]HistorySample `json:"instances"`
+
+	mutex sync.Mutex
+}
+
+// Summary of an instance's history for instxctl
+type InstanceHistory struct {
+	Url         string          `json:"url"`
+	Reliability float64         `json:"reliability"`
+	Samples     []HistorySample `json:"samples"`
+}
+
+func getHistoryPath() (string, error) {
+	cacheDir, err := util.GetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, HISTORY_FILE), nil
+}
+
+func loadHistory() (*History, error) {
+	history := &History{
+		Instances: make(map[string][]HistorySample),
+	}
+
+	path, err := getHistoryPath()
+	if err != nil {
+		return history, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return history, nil
+	} else if err != nil {
+		return history, err
+	}
+
+	if err := json.Unmarshal(data, history); err != nil {
+		return history, err
+	}
+	if history.Instances == nil {
+		history.Instances = make(map[string][]HistorySample)
+	}
+
+	return history, nil
+}
+
+func (h *History) save() error {
+	h.mutex.Lock()
+	data, err := json.Marshal(h)
+	h.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	path, err := getHistoryPath()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (h *History) record(url string, sample HistorySample) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.Instances[url] = append(h.Instances[url], sample)
+}
+
+// Set the rank of the samples taken at $at
+func (h *History) setRank(url string, at time.Time, rank int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	samples := h.Instances[url]
+	for i := len(samples) - 1; i >= 0; i-- {
+		if samples[i].Time.Equal(at) {
+			samples[i].Rank = rank
+			return
+		}
+	}
+}
+
+// Forget samples older than $maxAge
+func (h *History) prune(now time.Time, maxAge time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for url, samples := range h.Instances {
+		var kept []HistorySample
+		for _, sample := range samples {
+			if now.Sub(sample.Time) <= maxAge {
+				kept = append(kept, sample)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(h.Instances, url)
+		} else {
+			h.Instances[url] = kept
+		}
+	}
+}
+
+// The share of samples where the instance was up, from 0 to 1. Each sample
+// counts half as much every $halfLife, so an instance that's been solid
+// lately recovers from an outage last week. Returns false if there are no
+// samples.
+func reliability(samples []HistorySample, now time.Time, halfLife time.Duration) (float64, bool) {
+	var up float64
+	var total float64
+	for _, sample := range samples {
+		age := now.Sub(sample.Time)
+		if age < 0 {
+			age = 0
+		}
+
+		weight := math.Pow(0.5, float64(age)/float64(halfLife))
+		total += weight
+		if sample.Alive {
+			up += weight
+		}
+	}
+
+	if total == 0 {
+		return 0, false
+	}
+	return up / total, true
+}
+
+func (h *History) reliability(url string, now time.Time, halfLife time.Duration) (float64, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return reliability(h.Instances[url], now, halfLife)
+}
+
+var historyOnce sync.Once
+var history *History
+
+// Get the history, loading it from disk the first time
+func getHistory() *History {
+	historyOnce.Do(func() {
+		var err error
+		history, err = loadHistory()
+		if err != nil {
+			log.Printf("Could not load history: %s\n", err.Error())
+		}
+	})
+
+	return history
+}
+
+func getHistoryHalfLife() time.Duration {
+	return time.Duration(config.ParseConfig().Updater.History.HalfLife) * time.Hour
+}
+
+// Get the history of every instance whose URL contains $filter
+func GetHistory(filter string) []InstanceHistory {
+	h := getHistory()
+	now := time.Now()
+	halfLife := getHistoryHalfLife()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var ret []InstanceHistory
+	for url, samples := range h.Instances {
+		if !strings.Contains(url, filter) {
+			continue
+		}
+
+		r, _ := reliability(samples, now, halfLife)
+		ret = append(ret, InstanceHistory{
+			Url:         url,
+			Reliability: r,
+			Samples:     append([]HistorySample(nil), samples...),
+		})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Url < ret[j].Url
+	})
+	return ret
+}
+
+// Record whether each of $urls was up according to the latency tests and
+// search probes. Only instances that were actually tested are recorded.
+func recordHistory(urls []string, latencies map[string]LatencyResponse, probes map[string]SearchProbeResult, now time.Time) {
+	conf := config.ParseConfig().Updater.History
+	h := getHistory()
+
+	for _, url := range urls {
+
+		sample := HistorySample{
+			Time:  now,
+			Alive: true,
+		}
+		if latency, ok := latencies[url]; ok {
+			sample.Alive = latency.IsAlive
+			sample.Latency = latency.AvgLatency
+		}
+		if probe, ok := probes[url]; ok && !probe.Ok {
+			sample.Alive = false
+		}
+		h.record(url, sample)
+	}
+
+	h.prune(now, time.Duration(conf.MaxAge)*24*time.Hour)
+}
+
+// Copy each canidate's reliability out of the history
+func applyReliability(canidates *Canidates, now time.Time) {
+	h := getHistory()
+
+	for elem := canidates.Front(); elem != nil; elem = elem.Next() {
+		if canidate, ok := elem.Value.(Canidate); ok {
+			canidate.Reliability, _ = h.reliability(canidate.Url, now, getHistoryHalfLife())
+			elem.Value = canidate
+		}
+	}
+}
+
+// Record where each canidate ended up and save the history
+func recordRanks(canidates *Canidates, now time.Time) {
+	h := getHistory()
+
+	rank := 1
+	canidates.Iterate(func(canidate *Canidate) bool {
+		h.setRank(canidate.Url, now, rank)
+		rank++
+		return false
+	})
+
+	if err := h.save(); err != nil {
+		log.Printf("Could not save history: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,50 @@
+package updater
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestReliability(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	halfLife := 24 * time.Hour
+
+	if _, ok := reliability(nil, now, halfLife); ok {
+		t.Error("reliability of no samples should be unknown")
+	}
+
+	// Down a day ago, up now. The old sample counts half as much.
+	samples := []HistorySample{
+		{Time: now.Add(-24 * time.Hour), Alive: false},
+		{Time: now, Alive: true},
+	}
+	if got, _ := reliability(samples, now, halfLife); math.Abs(got-2.0/3.0) > 1e-9 {
+		t.Errorf("reliability = %f, want 0.667", got)
+	}
+
+	// The same outage a week ago barely matters
+	samples[0].Time = now.Add(-7 * 24 * time.Hour)
+	if got, _ := reliability(samples, now, halfLife); got < 0.99 {
+		t.Errorf("reliability = %f, want > 0.99", got)
+	}
+}
+
+func TestHistoryPrune(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	h := History{
+		Instances: map[string][]HistorySample{
+			"https://old.example/": {{Time: now.Add(-30 * 24 * time.Hour)}},
+			"https://new.example/": {{Time: now.Add(-30 * 24 * time.Hour)}, {Time: now}},
+		},
+	}
+
+	h.prune(now, 14*24*time.Hour)
+
+	if _, ok := h.Instances["https://old.example/"]; ok {
+		t.Error("instances with only old samples should be forgotten")
+	}
+	if n := len(h.Instances["https://new.example/"]); n != 1 {
+		t.Errorf("kept %d samples, want 1", n)
+	}
+}
@@ -0,0 +1,105 @@
+package updater
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/valyala/fastjson"
+)
+
+// A network an instance is reachable on. Fields are empty if unknown.
+type Network struct {
+
+	// e.g. "AS13335"
+	Asn string `json:"asn"`
+
+	// ISO 3166 country code
+	Country string `json:"country"`
+
+	// Who runs the network, e.g. "CLOUDFLARENET"
+	Provider string `json:"provider"`
+}
+
+func (n *Network) String() string {
+	if len(n.Asn) == 0 {
+		return "unknown network"
+	}
+	return fmt.Sprintf("%s (%s, %s)", n.Asn, n.Provider, n.Country)
+}
+
+// searx.space lists the networks instances are on separately from the
+// instances: each IP points to a CIDR, each CIDR to an ASN
+type asnTable struct {
+
+	// CIDR -> ASN
+	cidrs map[string]string
+
+	// ASN -> what's known about it
+	asns map[string]Network
+}
+
+// Get a string or number as a string
+func getJsonId(v *fastjson.Value) string {
+	if v == nil {
+		return ""
+	} else if v.Type() == fastjson.TypeString {
+		return string(v.GetStringBytes())
+	}
+	return v.String()
+}
+
+// Get ASNs in the same form whether they're given as "AS13335" or 13335
+func normalizeAsn(asn string) string {
+	asn = strings.ToUpper(strings.TrimSpace(asn))
+	if len(asn) == 0 || strings.HasPrefix(asn, "AS") {
+		return asn
+	}
+	return "AS" + asn
+}
+
+func parseAsnTable(jsonData *fastjson.Value) asnTable {
+	table := asnTable{
+		cidrs: make(map[string]string),
+		asns:  make(map[string]Network),
+	}
+
+	jsonData.GetObject("cidrs").Visit(func(k []byte, v *fastjson.Value) {
+		table.cidrs[string(k)] = normalizeAsn(getJsonId(v.Get("asn")))
+	})
+	jsonData.GetObject("asns").Visit(func(k []byte, v *fastjson.Value) {
+		provider := string(v.GetStringBytes("asn_description"))
+		if len(provider) == 0 {
+			provider = string(v.GetStringBytes("network_name"))
+		}
+
+		asn := normalizeAsn(string(k))
+		table.asns[asn] = Network{
+			Asn:      asn,
+			Country:  strings.ToUpper(string(v.GetStringBytes("asn_country_code"))),
+			Provider: provider,
+		}
+	})
+
+	return table
+}
+
+// Get every network the instance $v is reachable on, one for each ASN its
+// IPs are in. IPs in an unknown network show up as a Network without an ASN.
+func (t *asnTable) getNetworks(v *fastjson.Value) []Network {
+	var ret []Network
+	seen := make(map[string]bool)
+	v.GetObject("network", "ips").Visit(func(k []byte, ip *fastjson.Value) {
+		asn := t.cidrs[string(ip.GetStringBytes("asn_cidr"))]
+		if seen[asn] {
+			return
+		}
+		seen[asn] = true
+
+		network, ok := t.asns[asn]
+		if !ok {
+			network = Network{Asn: asn}
+		}
+		ret = append(ret, network)
+	})
+	return ret
+}
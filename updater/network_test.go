@@ -0,0 +1,49 @@
+package updater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/valyala/fastjson"
+)
+
+func TestGetNetworks(t *testing.T) {
+	jsonData := fastjson.MustParse(`{
+		"instances": {
+			"https://searx.example/": {
+				"network": {
+					"ipv6": true,
+					"ips": {
+						"192.0.2.1": {"asn_cidr": "192.0.2.0/24"},
+						"192.0.2.2": {"asn_cidr": "192.0.2.0/24"},
+						"2001:db8::1": {"asn_cidr": "2001:db8::/32"},
+						"198.51.100.1": {"asn_cidr": "198.51.100.0/24"}
+					}
+				}
+			}
+		},
+		"cidrs": {
+			"192.0.2.0/24": {"asn": "24940"},
+			"2001:db8::/32": {"asn": 13335}
+		},
+		"asns": {
+			"24940": {"asn_description": "HETZNER-AS", "asn_country_code": "de"},
+			"13335": {"asn_description": "CLOUDFLARENET", "asn_country_code": "us"}
+		}
+	}`)
+
+	table := parseAsnTable(jsonData)
+	got := table.getNetworks(jsonData.Get("instances", "https://searx.example/"))
+	want := []Network{
+		{Asn: "AS24940", Country: "DE", Provider: "HETZNER-AS"},
+		{Asn: "AS13335", Country: "US", Provider: "CLOUDFLARENET"},
+		{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getNetworks() = %+v, want %+v", got, want)
+	}
+
+	if got := table.getNetworks(fastjson.MustParse(`{"network": {"ips": {}}}`)); len(got) != 0 {
+		t.Errorf("getNetworks() = %+v, want nothing", got)
+	}
+}
@@ -0,0 +1,101 @@
+package updater
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"gitlab.com/Njinx/instx/config"
+)
+
+// Checks whether a response time is an outlier. Only slow outliers count;
+// being fast is never a problem. The mean method scales the response time
+// by its response weight. The robust methods don't, since scaling every
+// sample by the same weight wouldn't change which ones stand out.
+type outlierTest func(latency float64, weight float64) bool
+
+// Get the q-th quantile (0 <= q <= 1) of $values using linear interpolation
+func quantile(values []float64, q float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	pos := q * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
+
+func median(values []float64) float64 {
+	return quantile(values, 0.5)
+}
+
+// Today's behavior: anything slower than the average times a multiplier.
+// One very slow instance drags the average up with it.
+func newMeanOutlierTest(avg float64, multiplier float64) outlierTest {
+	return func(latency float64, weight float64) bool {
+		return (latency*weight > avg*multiplier) || (latency < 0)
+	}
+}
+
+// Median absolute deviation. Values with a modified z-score above $threshold
+// are outliers (Iglewicz and Hoaglin recommend 3.5).
+func newMadOutlierTest(values []float64, threshold float64) outlierTest {
+	med := median(values)
+
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - med)
+	}
+
+	// 0.6745 makes the MAD consistent with the standard deviation for normally
+	// distributed data. If more than half the values are identical the MAD is 0,
+	// so fall back to the mean absolute deviation.
+	scale := median(deviations) / 0.6745
+	if scale == 0 {
+		var sum float64
+		for _, d := range deviations {
+			sum += d
+		}
+		if len(deviations) > 0 {
+			scale = sum / float64(len(deviations)) * 1.253314
+		}
+	}
+
+	return func(latency float64, weight float64) bool {
+		if latency < 0 {
+			return true
+		}
+		if scale == 0 {
+			return latency > med
+		}
+		return (latency-med)/scale > threshold
+	}
+}
+
+// Tukey's fences. Values above Q3 + multiplier * IQR are outliers.
+func newIqrOutlierTest(values []float64, multiplier float64) outlierTest {
+	q1 := quantile(values, 0.25)
+	q3 := quantile(values, 0.75)
+	fence := q3 + multiplier*(q3-q1)
+
+	return func(latency float64, weight float64) bool {
+		return (latency > fence) || (latency < 0)
+	}
+}
+
+// Build the outlier test chosen by `updater.advanced.outlier_method` from
+// every published value of one response time
+func newOutlierTest(samples []float64, avg float64, conf *config.AdvancedConfig) outlierTest {
+	switch strings.ToLower(conf.OutlierMethod) {
+	case "mad":
+		return newMadOutlierTest(samples, conf.MadThreshold)
+	case "iqr":
+		return newIqrOutlierTest(samples, conf.IqrMultiplier)
+	default:
+		return newMeanOutlierTest(avg, conf.OutlierMultiplier)
+	}
+}
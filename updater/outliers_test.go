@@ -0,0 +1,89 @@
+package updater
+
+import "testing"
+
+// Typical search response times with one instance reporting 30s
+var skewedTimings = []float64{0.6, 0.7, 0.8, 0.8, 0.9, 1.0, 1.1, 1.2, 30.0}
+
+func TestQuantile(t *testing.T) {
+	values := []float64{4, 1, 3, 2}
+
+	if got := median(values); got != 2.5 {
+		t.Errorf("median = %f, want 2.5", got)
+	}
+	if got := quantile(values, 0.25); got != 1.75 {
+		t.Errorf("quantile(0.25) = %f, want 1.75", got)
+	}
+	if got := quantile(nil, 0.5); got != 0 {
+		t.Errorf("quantile(nil) = %f, want 0", got)
+	}
+}
+
+func TestOutlierTests(t *testing.T) {
+	var sum float64
+	for _, v := range skewedTimings {
+		sum += v
+	}
+	avg := sum / float64(len(skewedTimings))
+
+	tests := map[string]outlierTest{
+		"mean": newMeanOutlierTest(avg, 2.0),
+		"mad":  newMadOutlierTest(skewedTimings, 3.5),
+		"iqr":  newIqrOutlierTest(skewedTimings, 1.5),
+	}
+
+	for name, isOutlier := range tests {
+		if !isOutlier(30.0, 1.0) {
+			t.Errorf("%s: 30s should be an outlier", name)
+		}
+		if isOutlier(0.6, 1.0) {
+			t.Errorf("%s: 0.6s shouldn't be an outlier", name)
+		}
+		if !isOutlier(-1.0, 1.0) {
+			t.Errorf("%s: negative response times should be outliers", name)
+		}
+	}
+
+	// The 30s instance drags the mean up far enough that a 3s response
+	// time slips through, but the robust methods still catch it
+	if tests["mean"](3.0, 1.0) {
+		t.Error("mean: expected 3s to slip through")
+	}
+	if !tests["mad"](3.0, 1.0) {
+		t.Error("mad: 3s should be an outlier")
+	}
+	if !tests["iqr"](3.0, 1.0) {
+		t.Error("iqr: 3s should be an outlier")
+	}
+
+}
+
+func TestOutlierTestsKeepOrdinaryInstances(t *testing.T) {
+	populations := map[string][]float64{
+		"clustered": {1.0, 1.05, 1.1, 1.15, 1.2},
+		"single":    {1.1},
+	}
+
+	for population, samples := range populations {
+		var sum float64
+		for _, v := range samples {
+			sum += v
+		}
+		avg := sum / float64(len(samples))
+
+		tests := map[string]outlierTest{
+			"mean": newMeanOutlierTest(avg, 2.0),
+			"mad":  newMadOutlierTest(samples, 3.5),
+			"iqr":  newIqrOutlierTest(samples, 1.5),
+		}
+
+		// With the default search response weight
+		for name, isOutlier := range tests {
+			for _, sample := range samples {
+				if isOutlier(sample, 1.2) {
+					t.Errorf("%s (%s): %.2fs shouldn't be an outlier", name, population, sample)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package updater
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gitlab.com/Njinx/instx/config"
+	"gitlab.com/Njinx/instx/util"
+)
+
+const PENALTY_FILE = "penalties.json"
+
+// Instances users said were bad, kept until the penalty runs out
+type Penalties struct {
+	// URL -> when the penalty runs out
+	Instances map[string]time.Time `json:"instances"`
+
+	mutex sync.Mutex
+}
+
+func getPenaltyPath() (string, error) {
+	cacheDir, err := util.GetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, PENALTY_FILE), nil
+}
+
+func loadPenalties() (*Penalties, error) {
+	penalties := &Penalties{
+		Instances: make(map[string]time.Time),
+	}
+
+	path, err := getPenaltyPath()
+	if err != nil {
+		return penalties, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return penalties, nil
+	} else if err != nil {
+		return penalties, err
+	}
+
+	if err := json.Unmarshal(data, penalties); err != nil {
+		return penalties, err
+	}
+	if penalties.Instances == nil {
+		penalties.Instances = make(map[string]time.Time)
+	}
+
+	return penalties, nil
+}
+
+// mutex must be held
+func (p *Penalties) save() error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	path, err := getPenaltyPath()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Get when the penalty on $url runs out. The zero time if there isn't one.
+func (p *Penalties) get(url string, now time.Time) time.Time {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	until := p.Instances[url]
+	if !now.Before(until) {
+		return time.Time{}
+	}
+	return until
+}
+
+var penaltiesOnce sync.Once
+var penalties *Penalties
+
+// Get the penalties, loading them from disk the first time
+func getPenalties() *Penalties {
+	penaltiesOnce.Do(func() {
+		var err error
+		penalties, err = loadPenalties()
+		if err != nil {
+			log.Printf("Could not load penalties: %s\n", err.Error())
+		}
+	})
+
+	return penalties
+}
+
+// Penalize the instance at $url for $duration because a user said it was
+// bad. Expired penalties are forgotten.
+func PenalizeInstance(url string, duration time.Duration) time.Time {
+	p := getPenalties()
+	now := time.Now()
+	until := now.Add(duration)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for url, until := range p.Instances {
+		if !now.Before(until) {
+			delete(p.Instances, url)
+		}
+	}
+	p.Instances[url] = until
+
+	if err := p.save(); err != nil {
+		log.Printf("Could not save penalties: %s\n", err.Error())
+	}
+
+	return until
+}
+
+// Copy the penalties into the canidates so the scorer can see them
+func applyPenalties(canidates *Canidates, now time.Time) {
+	p := getPenalties()
+
+	for elem := canidates.Front(); elem != nil; elem = elem.Next() {
+		if canidate, ok := elem.Value.(Canidate); ok {
+			canidate.PenalizedUntil = p.get(canidate.Url, now)
+			elem.Value = canidate
+		}
+	}
+}
+
+// Score the canidates of profile $name again so that a new penalty counts
+// right away instead of after the next update
+func RescoreCanidates(name string, canidates *Canidates) {
+	c := config.ParseConfig()
+	profile, _ := c.GetProfile(name)
+	scoreCanidates(canidates, &profile.Advanced, time.Now())
+}
@@ -0,0 +1,292 @@
+package updater
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	urllib "net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-ping/ping"
+	"gitlab.com/Njinx/instx/config"
+)
+
+// Results of a live latency test. AvgLatency is in seconds and PacketLoss
+// is a percentage.
+type LatencyResponse struct {
+	Url        string  `json:"url"`
+	AvgLatency float64 `json:"avg_latency"`
+	IsAlive    bool    `json:"is_alive"`
+	PacketLoss float64 `json:"packet_loss"`
+}
+
+// Measures the round-trip time to an instance
+type Prober interface {
+	Probe(url *urllib.URL) (LatencyResponse, error)
+	String() string
+}
+
+// How many probes to send, how far apart, and how long to wait for all of
+// them in total
+type ProbeParams struct {
+	Count    int
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+type ErrUnknownProber struct {
+	Type string
+}
+
+func (err *ErrUnknownProber) Error() string {
+	return fmt.Sprintf("Unknown prober: \"%s\"", err.Type)
+}
+
+// Get the port to probe for $url
+func getProbePort(url *urllib.URL) string {
+	if port := url.Port(); port != "" {
+		return port
+	} else if url.Scheme == "http" {
+		return "80"
+	} else {
+		return "443"
+	}
+}
+
+// Set to 1 the first time unprivileged ICMP is refused. Shared by every
+// ICMPProber so that e.g. the retry round doesn't try (and log) it again.
+var icmpNotPermitted int32
+
+// Pings the instance's host. Unprivileged ICMP isn't allowed everywhere
+// (see net.ipv4.ping_group_range), so this falls back to a TCP prober once
+// it's been refused.
+type ICMPProber struct {
+	ProbeParams
+	Fallback Prober
+}
+
+func (p *ICMPProber) Probe(url *urllib.URL) (LatencyResponse, error) {
+	if atomic.LoadInt32(&icmpNotPermitted) == 1 {
+		return p.Fallback.Probe(url)
+	}
+
+	resp := LatencyResponse{
+		Url: url.String(),
+	}
+
+	pinger, err := ping.NewPinger(url.Hostname())
+	if err != nil {
+		return resp, err
+	}
+
+	pinger.Count = p.Count
+	pinger.Interval = p.Interval
+	pinger.Timeout = p.Timeout
+
+	// See: https://github.com/go-ping/ping#windows
+	if runtime.GOOS == "windows" {
+		pinger.SetPrivileged(true)
+	} else {
+		pinger.SetPrivileged(false)
+	}
+
+	err = pinger.Run()
+	if errors.Is(err, os.ErrPermission) {
+		if atomic.CompareAndSwapInt32(&icmpNotPermitted, 0, 1) {
+			log.Printf("ICMP isn't permitted, falling back to %s: %s\n", p.Fallback.String(), err.Error())
+		}
+		return p.Fallback.Probe(url)
+	} else if err != nil {
+		return resp, err
+	}
+
+	stats := pinger.Statistics()
+	resp.AvgLatency = stats.AvgRtt.Seconds()
+	resp.PacketLoss = stats.PacketLoss
+	resp.IsAlive = stats.PacketsRecv > 0
+
+	return resp, nil
+}
+
+func (p *ICMPProber) String() string {
+	return "ICMP"
+}
+
+// Repeatedly call $connect and time it. Unanswered attempts count as lost.
+func probeConnections(url *urllib.URL, params ProbeParams, connect func(deadline time.Time) error) LatencyResponse {
+	resp := LatencyResponse{
+		Url: url.String(),
+	}
+
+	var total time.Duration
+	var received int
+	deadline := time.Now().Add(params.Timeout)
+
+	for i := 0; i < params.Count && time.Now().Before(deadline); i++ {
+		if i > 0 {
+			time.Sleep(params.Interval)
+		}
+
+		start := time.Now()
+		if err := connect(deadline); err != nil {
+			continue
+		}
+		total += time.Since(start)
+		received++
+	}
+
+	if params.Count > 0 {
+		resp.PacketLoss = float64(params.Count-received) / float64(params.Count) * 100
+	}
+	if received > 0 {
+		resp.AvgLatency = (total / time.Duration(received)).Seconds()
+		resp.IsAlive = true
+	}
+
+	return resp
+}
+
+// Times TCP connections to the instance's HTTP(S) port
+type TCPProber struct {
+	ProbeParams
+}
+
+func (p *TCPProber) Probe(url *urllib.URL) (LatencyResponse, error) {
+	addr := net.JoinHostPort(url.Hostname(), getProbePort(url))
+
+	return probeConnections(url, p.ProbeParams, func(deadline time.Time) error {
+		dialer := net.Dialer{Deadline: deadline}
+		conn, err := dialer.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}), nil
+}
+
+func (p *TCPProber) String() string {
+	return "TCP"
+}
+
+// Times TCP connections plus the TLS handshake. Slower than TCPProber but
+// closer to what a browser actually experiences.
+type TLSProber struct {
+	ProbeParams
+}
+
+func (p *TLSProber) Probe(url *urllib.URL) (LatencyResponse, error) {
+	addr := net.JoinHostPort(url.Hostname(), getProbePort(url))
+
+	return probeConnections(url, p.ProbeParams, func(deadline time.Time) error {
+		dialer := net.Dialer{Deadline: deadline}
+		conn, err := tls.DialWithDialer(&dialer, "tcp", addr, &tls.Config{
+			ServerName: url.Hostname(),
+		})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}), nil
+}
+
+func (p *TLSProber) String() string {
+	return "TLS"
+}
+
+func NewProber(kind string, params ProbeParams) (Prober, error) {
+	switch strings.ToLower(kind) {
+	case "icmp":
+		return &ICMPProber{
+			ProbeParams: params,
+			Fallback:    &TCPProber{params},
+		}, nil
+	case "tcp":
+		return &TCPProber{params}, nil
+	case "tls":
+		return &TLSProber{params}, nil
+	default:
+		return nil, &ErrUnknownProber{kind}
+	}
+}
+
+// Get the probe parameters set in instx.yaml
+func getProbeParams() ProbeParams {
+	conf := config.ParseConfig().Updater.Probe
+	return ProbeParams{
+		Count:    conf.Count,
+		Interval: time.Duration(conf.Interval) * time.Millisecond,
+		Timeout:  time.Duration(conf.Timeout) * time.Millisecond,
+	}
+}
+
+// Get the prober set in instx.yaml
+func getProber(params ProbeParams) Prober {
+	kind := config.ParseConfig().Updater.Probe.Type
+	prober, err := NewProber(kind, params)
+	if err != nil {
+		log.Printf("%s, falling back to ICMP\n", err.Error())
+		prober, _ = NewProber("icmp", params)
+	}
+
+	return prober
+}
+
+// Helper function that conducts latency tests
+func doLatencyTestsEx(urls []string, prober Prober) []LatencyResponse {
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	var ret []LatencyResponse
+
+	for _, url := range urls {
+		wg.Add(1)
+
+		// Don't block during latency tests
+		go func(url string) {
+			defer wg.Done()
+
+			resp := LatencyResponse{
+				Url: url,
+			}
+
+			parsedUrl, err := urllib.Parse(url)
+			if err != nil {
+				log.Printf("Could not parse URL \"%s\": %s", url, err.Error())
+			} else if probed, err := prober.Probe(parsedUrl); err != nil {
+				log.Printf("Could not probe \"%s\": %s\n", url, err.Error())
+			} else {
+				resp = probed
+				resp.Url = url
+			}
+
+			m.Lock()
+			ret = append(ret, resp)
+			m.Unlock()
+		}(url)
+	}
+
+	wg.Wait()
+	return ret
+}
+
+// Basic latency test
+func doLatencyTests(urls []string) []LatencyResponse {
+	return doLatencyTestsEx(urls, getProber(getProbeParams()))
+}
+
+// More intensive latency test. Twice as many probes, spaced further apart,
+// with more time to respond.
+func doLatencyTestsIntensive(urls []string) []LatencyResponse {
+	params := getProbeParams()
+	params.Count *= 2
+	params.Interval *= 10
+	params.Timeout *= 4
+
+	return doLatencyTestsEx(urls, getProber(params))
+}
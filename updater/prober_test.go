@@ -0,0 +1,39 @@
+package updater
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	urllib "net/url"
+	"testing"
+	"time"
+)
+
+func TestTCPProber(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	url, _ := urllib.Parse(server.URL)
+	prober := TCPProber{ProbeParams{Count: 3, Interval: 10 * time.Millisecond, Timeout: time.Second}}
+
+	resp, err := prober.Probe(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsAlive || resp.PacketLoss != 0 {
+		t.Errorf("expected every probe to succeed, got isAlive = %t, packetLoss = %f", resp.IsAlive, resp.PacketLoss)
+	}
+
+	// Grab a port that nothing is listening on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedUrl, _ := urllib.Parse("http://" + listener.Addr().String())
+	listener.Close()
+
+	resp, _ = prober.Probe(closedUrl)
+	if resp.IsAlive || resp.PacketLoss != 100 {
+		t.Errorf("expected every probe to fail, got isAlive = %t, packetLoss = %f", resp.IsAlive, resp.PacketLoss)
+	}
+}
@@ -0,0 +1,225 @@
+package updater
+
+import (
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"gitlab.com/Njinx/instx/config"
+)
+
+// Ranks canidates by setting their scores. Lower scores are better.
+type Scorer interface {
+	Score(canidates *Canidates)
+	String() string
+}
+
+// Scores canidates on response time alone. Everything else is left to the
+// criteria, except that canidates penalized at $now have $penalty added.
+type ResponseTimeScorer struct {
+	penalty float64
+	now     time.Time
+}
+
+func NewResponseTimeScorer(penalty float64, now time.Time) *ResponseTimeScorer {
+	return &ResponseTimeScorer{penalty: penalty, now: now}
+}
+
+func (s *ResponseTimeScorer) Score(canidates *Canidates) {
+	for elem := canidates.Front(); elem != nil; elem = elem.Next() {
+		if canidate, ok := elem.Value.(Canidate); ok {
+
+			// Honest to God I have no idea what's happening here
+			canidate.Score = math.Floor(canidate.ResponseTime*100) / 100
+			if s.now.Before(canidate.PenalizedUntil) {
+				canidate.Score += s.penalty
+			}
+			elem.Value = canidate
+		}
+	}
+}
+
+func (s *ResponseTimeScorer) String() string {
+	return "response_time"
+}
+
+// One thing NormalizedScorer takes into account. $value returns false if the
+// canidate doesn't have it.
+type scoreMetric struct {
+	weight float64
+	value  func(canidate *Canidate) (float64, bool)
+
+	// Whether higher values are better
+	higherIsBetter bool
+
+	// Whether $value is already between 0 (best) and 1 (worst). Otherwise
+	// it's scaled between the best and worst canidates.
+	absolute bool
+}
+
+// Missing metrics neither help nor hurt
+const NEUTRAL_METRIC = 0.5
+
+// Get the badness of each canidate for $metric, from 0 (best) to 1 (worst)
+func (m *scoreMetric) normalize(canidates []*Canidate) []float64 {
+	values := make([]float64, len(canidates))
+	known := make([]bool, len(canidates))
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i, canidate := range canidates {
+		values[i], known[i] = m.value(canidate)
+		if known[i] {
+			lo = math.Min(lo, values[i])
+			hi = math.Max(hi, values[i])
+		}
+	}
+
+	ret := make([]float64, len(canidates))
+	for i := range canidates {
+		switch {
+		case !known[i]:
+			ret[i] = NEUTRAL_METRIC
+		case m.absolute:
+			ret[i] = math.Max(0, math.Min(1, values[i]))
+		case hi == lo:
+			ret[i] = 0
+		case m.higherIsBetter:
+			ret[i] = (hi - values[i]) / (hi - lo)
+		default:
+			ret[i] = (values[i] - lo) / (hi - lo)
+		}
+	}
+
+	return ret
+}
+
+// Scores canidates on several metrics, each scaled from 0 (best) to 1
+// (worst) and then averaged using the weights in
+// `updater.advanced.score_weights`. This lets a much faster instance outrank
+// one with slightly better security grades, if that's what you want.
+type NormalizedScorer struct {
+	metrics []scoreMetric
+}
+
+// How far a letter grade is from an A+, from 0 (A+) to 1 (F)
+func gradeBadness(grade string) (float64, bool) {
+	n := schoolScaleToInt(grade)
+	if n < 0 {
+		return 0, false
+	}
+	return float64(100-n) / 50, true
+}
+
+// $uptimePeriod is the period the uptime metric looks at: day, week or month
+func NewNormalizedScorer(weights *config.ScoreWeights, uptimePeriod string, historyEnabled bool, now time.Time) *NormalizedScorer {
+	return &NormalizedScorer{
+		metrics: []scoreMetric{
+			{
+				weight: weights.Latency,
+				value: func(canidate *Canidate) (float64, bool) {
+					return canidate.ResponseTime, true
+				},
+			},
+			{
+				weight:   weights.TlsGrade,
+				absolute: true,
+				value: func(canidate *Canidate) (float64, bool) {
+					return gradeBadness(canidate.TlsGrade)
+				},
+			},
+			{
+				weight:   weights.CspGrade,
+				absolute: true,
+				value: func(canidate *Canidate) (float64, bool) {
+					return gradeBadness(canidate.CspGrade)
+				},
+			},
+			{
+				weight:         weights.Uptime,
+				higherIsBetter: true,
+				value: func(canidate *Canidate) (float64, bool) {
+					return canidate.Uptime.get(uptimePeriod)
+				},
+			},
+			{
+				weight:   weights.Reliability,
+				absolute: true,
+				value: func(canidate *Canidate) (float64, bool) {
+					return 1 - canidate.Reliability, historyEnabled
+				},
+			},
+			{
+				weight:   weights.Feedback,
+				absolute: true,
+				value: func(canidate *Canidate) (float64, bool) {
+					if now.Before(canidate.PenalizedUntil) {
+						return 1, true
+					}
+					return 0, true
+				},
+			},
+			{
+				weight: weights.VersionAge,
+				value: func(canidate *Canidate) (float64, bool) {
+					released, ok := parseVersionDate(canidate.Version)
+					return now.Sub(released).Hours() / 24, ok
+				},
+			},
+		},
+	}
+}
+
+func (s *NormalizedScorer) Score(canidates *Canidates) {
+	var elems []*Canidate
+	for elem := canidates.Front(); elem != nil; elem = elem.Next() {
+		if canidate, ok := elem.Value.(Canidate); ok {
+			elems = append(elems, &canidate)
+		}
+	}
+
+	scores := make([]float64, len(elems))
+	var totalWeight float64
+	for _, metric := range s.metrics {
+		if metric.weight <= 0 {
+			continue
+		}
+
+		for i, badness := range metric.normalize(elems) {
+			scores[i] += metric.weight * badness
+		}
+		totalWeight += metric.weight
+	}
+
+	i := 0
+	for elem := canidates.Front(); elem != nil; elem = elem.Next() {
+		if canidate, ok := elem.Value.(Canidate); ok {
+			if totalWeight > 0 {
+				canidate.Score = math.Round(scores[i]/totalWeight*1000) / 1000
+			} else {
+				canidate.Score = 0
+			}
+			elem.Value = canidate
+			i++
+		}
+	}
+}
+
+func (s *NormalizedScorer) String() string {
+	return "normalized"
+}
+
+// Get the scorer set by $conf
+func getScorer(conf *config.AdvancedConfig) Scorer {
+	historyEnabled := config.ParseConfig().Updater.History.Enabled
+
+	switch strings.ToLower(conf.Scorer) {
+	case "response_time":
+		return NewResponseTimeScorer(conf.ProbeFailurePenalty, time.Now())
+	case "normalized":
+		return NewNormalizedScorer(&conf.ScoreWeights, conf.UptimePeriod, historyEnabled, time.Now())
+	default:
+		log.Printf("Unknown scorer \"%s\", falling back to normalized\n", conf.Scorer)
+		return NewNormalizedScorer(&conf.ScoreWeights, conf.UptimePeriod, historyEnabled, time.Now())
+	}
+}
@@ -0,0 +1,109 @@
+package updater
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.com/Njinx/instx/config"
+)
+
+func newTestCanidates(list ...Canidate) Canidates {
+	canidates := NewCanidates()
+	for _, canidate := range list {
+		canidates.PushBack(canidate)
+	}
+	return canidates
+}
+
+func TestNormalizedScorer(t *testing.T) {
+	now := time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)
+	fastB := Canidate{
+		Instance:     Instance{Url: "https://fast.example/", TlsGrade: "B+", CspGrade: "A+", Version: "2023.11.1+abc"},
+		ResponseTime: 0.4,
+	}
+	slowA := Canidate{
+		Instance:     Instance{Url: "https://slow.example/", TlsGrade: "A+", CspGrade: "A+", Version: "2023.11.1+abc"},
+		ResponseTime: 2.0,
+	}
+
+	// Latency matters more than the TLS grade
+	canidates := newTestCanidates(slowA, fastB)
+	NewNormalizedScorer(&config.ScoreWeights{Latency: 1.0, TlsGrade: 0.25}, "month", false, now).Score(&canidates)
+	canidates.Sort()
+	if got := canidates.Get(0).Url; got != fastB.Url {
+		t.Errorf("expected the fast B+ instance to win, got %s", got)
+	}
+
+	// The TLS grade matters more than latency
+	canidates = newTestCanidates(fastB, slowA)
+	NewNormalizedScorer(&config.ScoreWeights{Latency: 0.1, TlsGrade: 1.0}, "month", false, now).Score(&canidates)
+	canidates.Sort()
+	if got := canidates.Get(0).Url; got != slowA.Url {
+		t.Errorf("expected the slow A+ instance to win, got %s", got)
+	}
+
+	// Being up matters more than being slightly faster
+	flaky := Canidate{
+		Instance:     Instance{Url: "https://flaky.example/", Uptime: Uptime{Week: Percentage{50, true}}},
+		ResponseTime: 0.5,
+	}
+	reliable := Canidate{
+		Instance:     Instance{Url: "https://reliable.example/", Uptime: Uptime{Week: Percentage{100, true}}},
+		ResponseTime: 0.6,
+	}
+	slow := Canidate{
+		Instance:     Instance{Url: "https://slow.example/", Uptime: Uptime{Week: Percentage{100, true}}},
+		ResponseTime: 2.0,
+	}
+	canidates = newTestCanidates(flaky, reliable, slow)
+	NewNormalizedScorer(&config.ScoreWeights{Latency: 1.0, Uptime: 0.25}, "week", false, now).Score(&canidates)
+	canidates.Sort()
+	if got := canidates.Get(0).Url; got != reliable.Url {
+		t.Errorf("expected the reliable instance to win, got %s", got)
+	}
+
+	// Every score is between 0 and 1
+	canidates.Iterate(func(canidate *Canidate) bool {
+		if canidate.Score < 0 || canidate.Score > 1 {
+			t.Errorf("score out of range: %f", canidate.Score)
+		}
+		return false
+	})
+}
+
+func TestResponseTimeScorerPenalty(t *testing.T) {
+	now := time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)
+	penalized := Canidate{
+		Instance:       Instance{Url: "https://penalized.example/"},
+		ResponseTime:   0.4,
+		PenalizedUntil: now.Add(time.Hour),
+	}
+	slow := Canidate{
+		Instance:     Instance{Url: "https://slow.example/"},
+		ResponseTime: 2.0,
+	}
+
+	canidates := newTestCanidates(penalized, slow)
+	NewResponseTimeScorer(5.0, now).Score(&canidates)
+	canidates.Sort()
+	if got := canidates.Get(0).Url; got != slow.Url {
+		t.Errorf("expected the penalized instance to lose, got %s first", got)
+	}
+
+	// Expired penalties don't count
+	canidates = newTestCanidates(penalized, slow)
+	NewResponseTimeScorer(5.0, now.Add(2*time.Hour)).Score(&canidates)
+	canidates.Sort()
+	if got := canidates.Get(0).Url; got != penalized.Url {
+		t.Errorf("expected the fast instance to win once its penalty expired, got %s", got)
+	}
+}
+
+func TestParseVersionDate(t *testing.T) {
+	if date, ok := parseVersionDate("2023.10.22+526d5c7b3"); !ok || !date.Equal(time.Date(2023, 10, 22, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected date %s", date)
+	}
+	if _, ok := parseVersionDate("1.1.0-69-75b859d2"); ok {
+		t.Error("SearX versions don't have a date")
+	}
+}
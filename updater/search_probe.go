@@ -0,0 +1,214 @@
+package updater
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	urllib "net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Don't read more than this much of a search page
+const SEARCH_PROBE_MAX_BODY = 4 << 20
+
+// Some instances turn away anything that doesn't look like a browser
+const SEARCH_PROBE_USER_AGENT = "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/115.0"
+
+// The outcome of running a real search against an instance. Times are in
+// seconds.
+type SearchProbeResult struct {
+	Dns    float64 `json:"dns"`
+	Tls    float64 `json:"tls"`
+	Ttfb   float64 `json:"ttfb"`
+	Ok     bool    `json:"ok"`
+	Reason string  `json:"reason,omitempty"`
+}
+
+type ErrBadSearchResponse struct {
+	Reason string
+}
+
+func (err *ErrBadSearchResponse) Error() string {
+	return err.Reason
+}
+
+var (
+	// SearX(NG) themes wrap every result in an element with the "result" class
+	resultRegexp = regexp.MustCompile(`class="result[ "]`)
+
+	captchaMarkers = []string{
+		"g-recaptcha", "h-captcha", "hcaptcha", "cf-challenge",
+		"challenge-platform", "captcha-container",
+	}
+	rateLimitMarkers = []string{
+		"too many requests", "rate limit", "ratelimit",
+	}
+)
+
+// Errors and rate limits, as opposed to statuses like 404
+func checkServerStatus(status int) error {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return &ErrBadSearchResponse{"Rate limited (429)"}
+	case status >= 500:
+		return &ErrBadSearchResponse{fmt.Sprintf("Server error (%d)", status)}
+	}
+	return nil
+}
+
+// Look for signs of a CAPTCHA or rate-limit page
+func checkBlockPage(body []byte) error {
+	lowerBody := bytes.ToLower(body)
+	for _, marker := range captchaMarkers {
+		if bytes.Contains(lowerBody, []byte(marker)) {
+			return &ErrBadSearchResponse{"CAPTCHA"}
+		}
+	}
+	for _, marker := range rateLimitMarkers {
+		if bytes.Contains(lowerBody, []byte(marker)) {
+			return &ErrBadSearchResponse{"Rate limited"}
+		}
+	}
+	return nil
+}
+
+// Check that a search page actually contains results and isn't a CAPTCHA or
+// rate-limit page in disguise.
+func validateSearchResponse(status int, body []byte) error {
+	if err := checkServerStatus(status); err != nil {
+		return err
+	} else if status != http.StatusOK {
+		return &ErrBadSearchResponse{fmt.Sprintf("Unexpected status (%d)", status)}
+	}
+
+	// Pages with results are fine even if they mention a CAPTCHA. SearX lists
+	// engines that were suspended for CAPTCHAs alongside the results.
+	if resultRegexp.Match(body) {
+		return nil
+	}
+
+	if err := checkBlockPage(body); err != nil {
+		return err
+	}
+	return &ErrBadSearchResponse{"No results"}
+}
+
+// Check whether a page from an instance is an error, CAPTCHA or rate-limit
+// page. Unlike validateSearchResponse, pages without results are fine since
+// not every page is a search.
+func CheckBlocked(status int, body []byte) error {
+	if err := checkServerStatus(status); err != nil {
+		return err
+	}
+	if resultRegexp.Match(body) {
+		return nil
+	}
+	return checkBlockPage(body)
+}
+
+// Search for $query on the instance at $url, timing each step
+func doSearchProbe(url string, query string, timeout time.Duration) SearchProbeResult {
+	var result SearchProbeResult
+
+	fail := func(err error) SearchProbeResult {
+		result.Ok = false
+		result.Reason = err.Error()
+		return result
+	}
+
+	searchUrl, err := urllib.Parse(url)
+	if err != nil {
+		return fail(err)
+	}
+	searchUrl.Path = strings.TrimSuffix(searchUrl.Path, "/") + "/search"
+	searchUrl.RawQuery = urllib.Values{"q": {query}}.Encode()
+
+	var start, dnsStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			result.Dns = time.Since(dnsStart).Seconds()
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			result.Tls = time.Since(tlsStart).Seconds()
+		},
+		GotFirstResponseByte: func() {
+			result.Ttfb = time.Since(start).Seconds()
+		},
+	}
+
+	req, err := http.NewRequest("GET", searchUrl.String(), nil)
+	if err != nil {
+		return fail(err)
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	req.Header.Set("User-Agent", SEARCH_PROBE_USER_AGENT)
+	req.Header.Set("Accept", "text/html")
+
+	// A fresh connection every time, otherwise there's nothing to time
+	client := http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			DisableKeepAlives: true,
+		},
+	}
+
+	start = time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, SEARCH_PROBE_MAX_BODY))
+	if err != nil {
+		return fail(err)
+	}
+
+	if err := validateSearchResponse(resp.StatusCode, body); err != nil {
+		return fail(err)
+	}
+
+	result.Ok = true
+	return result
+}
+
+// Probe every URL concurrently. Returns url -> result.
+func doSearchProbes(urls []string, query string, timeout time.Duration) map[string]SearchProbeResult {
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	ret := make(map[string]SearchProbeResult)
+
+	for _, url := range urls {
+		wg.Add(1)
+
+		go func(url string) {
+			defer wg.Done()
+
+			result := doSearchProbe(url, query, timeout)
+			if !result.Ok {
+				log.Printf("Search probe failed for \"%s\": %s\n", url, result.Reason)
+			}
+
+			m.Lock()
+			ret[url] = result
+			m.Unlock()
+		}(url)
+	}
+
+	wg.Wait()
+	return ret
+}
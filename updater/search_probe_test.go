@@ -0,0 +1,74 @@
+package updater
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const resultsPage = `<html><body><div id="results">
+<article class="result result-default category-general">
+<a href="https://example.com">Example</a></article>
+<div id="engines_msg">google (Suspended: CAPTCHA)</div>
+</div></body></html>`
+
+const captchaPage = `<html><body><div class="g-recaptcha" data-sitekey="x"></div></body></html>`
+
+const rateLimitPage = `<html><body><h1>Too Many Requests</h1></body></html>`
+
+const emptyPage = `<html><body><div id="results"></div></body></html>`
+
+func TestValidateSearchResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		ok     bool
+	}{
+		{"results", http.StatusOK, resultsPage, true},
+		{"captcha", http.StatusOK, captchaPage, false},
+		{"rate limit page", http.StatusOK, rateLimitPage, false},
+		{"no results", http.StatusOK, emptyPage, false},
+		{"429", http.StatusTooManyRequests, resultsPage, false},
+		{"502", http.StatusBadGateway, resultsPage, false},
+		{"403", http.StatusForbidden, resultsPage, false},
+	}
+
+	for _, test := range tests {
+		err := validateSearchResponse(test.status, []byte(test.body))
+		if test.ok && err != nil {
+			t.Errorf("%s: expected success, got \"%s\"", test.name, err.Error())
+		} else if !test.ok && err == nil {
+			t.Errorf("%s: expected failure", test.name)
+		}
+	}
+}
+
+func TestDoSearchProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/search" || req.URL.Query().Get("q") != "test" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(resultsPage))
+	}))
+	defer server.Close()
+
+	result := doSearchProbe(server.URL+"/", "test", 5*time.Second)
+	if !result.Ok {
+		t.Fatalf("expected the probe to succeed: %s", result.Reason)
+	}
+	if result.Ttfb <= 0 {
+		t.Errorf("expected a time to first byte, got %f", result.Ttfb)
+	}
+
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer limited.Close()
+
+	if result := doSearchProbe(limited.URL, "test", 5*time.Second); result.Ok {
+		t.Error("expected the probe to fail on a 429")
+	}
+}
@@ -0,0 +1,297 @@
+package updater
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	urllib "net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gitlab.com/Njinx/instx/config"
+)
+
+const SEARX_SPACE_URL = "https://searx.space/data/instances.json"
+
+const REMOTE_SOURCE_TIMEOUT = 30 * time.Second
+
+// A directory of SearX(NG) instances
+type Source interface {
+
+	// Get every instance listed by the source
+	Instances() (Instances, error)
+	String() string
+}
+
+type ErrUnexpectedStatus struct {
+	Url    string
+	Status string
+}
+
+func (err *ErrUnexpectedStatus) Error() string {
+	return fmt.Sprintf("Unexpected response from \"%s\": %s", err.Url, err.Status)
+}
+
+type ErrUnknownSource struct {
+	Type string
+}
+
+func (err *ErrUnknownSource) Error() string {
+	return fmt.Sprintf("Unknown instance source: \"%s\"", err.Type)
+}
+
+type ErrNoInstances struct{}
+
+func (err *ErrNoInstances) Error() string {
+	return "None of the instance sources could be read"
+}
+
+// A searx.space-compatible instances.json served over HTTP(S)
+type RemoteSource struct {
+	Url string
+}
+
+// Fetch the instances, revalidating the on-disk copy with ETag and
+// If-Modified-Since. If the fetch fails, the on-disk copy is used instead
+// and the instances are marked as stale.
+func (s *RemoteSource) Instances() (Instances, error) {
+	cache, err := loadSourceCache(s.Url)
+	if err != nil {
+		log.Printf("Could not read cached response for \"%s\": %s\n", s.Url, err.Error())
+		cache = nil
+	}
+
+	useCache := func(reason error) (Instances, error) {
+		if cache == nil {
+			return Instances{}, reason
+		}
+
+		log.Printf("Could not fetch \"%s\", using the copy from %s: %s\n",
+			s.Url, cache.Fetched.Format(time.RFC1123), reason.Error())
+
+		ret, err := parseInstancesJson(cache.body)
+		if err != nil {
+			return Instances{}, err
+		}
+		ret.stale = true
+		return ret, nil
+	}
+
+	req, err := http.NewRequest("GET", s.Url, nil)
+	if err != nil {
+		return Instances{}, err
+	}
+	if cache != nil {
+		if cache.ETag != "" {
+			req.Header.Set("If-None-Match", cache.ETag)
+		}
+		if cache.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cache.LastModified)
+		}
+	}
+
+	client := http.Client{
+		Timeout: REMOTE_SOURCE_TIMEOUT,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return useCache(err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		break
+	case http.StatusNotModified:
+		if cache != nil {
+			cache.Fetched = time.Now()
+			if err := cache.save(); err != nil {
+				log.Printf("Could not cache response for \"%s\": %s\n", s.Url, err.Error())
+			}
+			return parseInstancesJson(cache.body)
+		}
+		fallthrough
+	default:
+		return useCache(&ErrUnexpectedStatus{s.Url, resp.Status})
+	}
+
+	jsonResp, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return useCache(err)
+	}
+
+	ret, err := parseInstancesJson(jsonResp)
+	if err != nil {
+		return useCache(err)
+	}
+
+	// Only cache responses that actually parse
+	newCache := sourceCache{
+		Url:          s.Url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Fetched:      time.Now(),
+		body:         jsonResp,
+	}
+	if err := newCache.save(); err != nil {
+		log.Printf("Could not cache response for \"%s\": %s\n", s.Url, err.Error())
+	}
+
+	return ret, nil
+}
+
+func (s *RemoteSource) String() string {
+	return s.Url
+}
+
+// A searx.space-compatible instances.json on the local filesystem
+type FileSource struct {
+	Path string
+}
+
+func (s *FileSource) Instances() (Instances, error) {
+	jsonData, err := os.ReadFile(s.Path)
+	if err != nil {
+		return Instances{}, err
+	}
+
+	return parseInstancesJson(jsonData)
+}
+
+func (s *FileSource) String() string {
+	return s.Path
+}
+
+// A hand-picked list of instance URLs. Since there's no searx.space data
+// for these, the criteria don't apply and their timings are unknown.
+type StaticSource struct {
+	Urls []string
+}
+
+func (s *StaticSource) Instances() (Instances, error) {
+	var ret Instances
+	for _, url := range s.Urls {
+		ret.instanceList = append(ret.instanceList, Instance{
+			Url:    url,
+			Static: true,
+		})
+	}
+
+	return ret, nil
+}
+
+func (s *StaticSource) String() string {
+	return fmt.Sprintf("static list (%d instances)", len(s.Urls))
+}
+
+func NewSource(conf config.SourceConfig) (Source, error) {
+	switch strings.ToLower(conf.Type) {
+	case "remote":
+		return &RemoteSource{conf.Url}, nil
+	case "file":
+		return &FileSource{conf.Path}, nil
+	case "static":
+		return &StaticSource{conf.Instances}, nil
+	default:
+		return nil, &ErrUnknownSource{conf.Type}
+	}
+}
+
+// Get the sources configured in instx.yaml. Defaults to searx.space.
+func getSources() []Source {
+	var ret []Source
+	for _, sourceConf := range config.ParseConfig().Updater.Sources {
+		source, err := NewSource(sourceConf)
+		if err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		ret = append(ret, source)
+	}
+
+	if len(ret) == 0 {
+		ret = append(ret, &RemoteSource{SEARX_SPACE_URL})
+	}
+	return ret
+}
+
+// Get the hosts of the URLs in $blacklist
+func getBlacklistedHosts(blacklist []string) map[string]bool {
+	ret := make(map[string]bool)
+	for _, blisted := range blacklist {
+		blistUrlParsed, err := urllib.Parse(blisted)
+		if err != nil {
+			log.Printf("Could not parse URL \"%s\": %s\n", blisted, err.Error())
+			continue
+		}
+		ret[blistUrlParsed.Host] = true
+	}
+	return ret
+}
+
+// Combine the instances from every source. When more than one source lists
+// the same host, the first one wins. Instances in $blacklist are left out no
+// matter which source lists them. A host that one source rejects but
+// another lists is used.
+func fetchInstances(sources []Source, blacklist []string) (Instances, error) {
+	var ret Instances
+	var rejected []Rejection
+	seen := make(map[string]bool)
+	accepted := make(map[string]bool)
+	blacklisted := getBlacklistedHosts(blacklist)
+	ok := false
+
+	for _, source := range sources {
+		instances, err := source.Instances()
+		if err != nil {
+			log.Printf("Could not get instances from \"%s\": %s\n", source.String(), err.Error())
+			continue
+		}
+		ok = true
+		ret.stale = ret.stale || instances.stale
+		rejected = append(rejected, instances.rejected...)
+
+		for _, inst := range instances.instanceList {
+			parsedUrl, err := urllib.Parse(inst.Url)
+			if err != nil {
+				log.Printf("Could not parse URL \"%s\": %s\n", inst.Url, err.Error())
+				continue
+			}
+
+			if seen[parsedUrl.Host] {
+				continue
+			}
+			seen[parsedUrl.Host] = true
+
+			if blacklisted[parsedUrl.Host] {
+				rejected = append(rejected, Rejection{inst.Url, "Blacklisted"})
+				continue
+			}
+
+			accepted[parsedUrl.Host] = true
+			ret.instanceList = append(ret.instanceList, inst)
+		}
+	}
+
+	if !ok {
+		return Instances{}, &ErrNoInstances{}
+	}
+
+	// Each host is either a canidate or rejected once
+	rejectedHosts := make(map[string]bool)
+	for _, rejection := range rejected {
+		host := rejection.Url
+		if parsedUrl, err := urllib.Parse(rejection.Url); err == nil {
+			host = parsedUrl.Host
+		}
+
+		if !accepted[host] && !rejectedHosts[host] {
+			rejectedHosts[host] = true
+			ret.rejected = append(ret.rejected, rejection)
+		}
+	}
+
+	return ret, nil
+}
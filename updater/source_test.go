@@ -0,0 +1,49 @@
+package updater
+
+import "testing"
+
+// Returns fixed instances
+type testSource struct {
+	instances Instances
+}
+
+func (s *testSource) Instances() (Instances, error) {
+	return s.instances, nil
+}
+
+func (s *testSource) String() string {
+	return "test"
+}
+
+func TestFetchInstances(t *testing.T) {
+	rejecting := &testSource{Instances{
+		rejected: []Rejection{
+			{"https://a.example/", "No timing data"},
+			{"https://c.example/", "No timing data"},
+		},
+	}}
+	listing := &StaticSource{[]string{"https://a.example/", "https://b.example/"}}
+
+	instances, err := fetchInstances([]Source{rejecting, listing, rejecting}, []string{"https://b.example/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(instances.instanceList) != 1 || instances.instanceList[0].Url != "https://a.example/" {
+		t.Errorf("instances = %+v, want only a.example", instances.instanceList)
+	}
+
+	// a.example is a canidate after all and c.example is only listed once
+	want := map[string]string{
+		"https://b.example/": "Blacklisted",
+		"https://c.example/": "No timing data",
+	}
+	if len(instances.rejected) != len(want) {
+		t.Errorf("rejected = %+v, want %v", instances.rejected, want)
+	}
+	for _, rejection := range instances.rejected {
+		if want[rejection.Url] != rejection.Reason {
+			t.Errorf("unexpected rejection %+v", rejection)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package updater
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gitlab.com/Njinx/instx/util"
+)
+
+const STATE_FILE = "state.json"
+
+func getStatePath() (string, error) {
+	cacheDir, err := util.GetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, STATE_FILE), nil
+}
+
+// What's saved to STATE_FILE
+type stateMarshalable struct {
+	Profiles map[string]CanidatesMarshalable `json:"profiles"`
+}
+
+// Save the ranked canidates of every profile so they can be restored at
+// startup
+func saveState(profiles ProfileCanidates) error {
+	path, err := getStatePath()
+	if err != nil {
+		return err
+	}
+
+	state := stateMarshalable{
+		Profiles: make(map[string]CanidatesMarshalable),
+	}
+	for name, canidates := range profiles {
+		state.Profiles[name] = NewCanidatesMarshalable(canidates)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial state
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Load the canidates saved by the last update. Profiles that weren't saved or
+// are older than $maxAge are left out.
+func loadState(maxAge time.Duration) (ProfileCanidates, error) {
+	profiles := make(ProfileCanidates)
+
+	path, err := getStatePath()
+	if err != nil {
+		return profiles, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return profiles, nil
+	} else if err != nil {
+		return profiles, err
+	}
+
+	// State saved before profiles existed has no "profiles" and is ignored
+	var state stateMarshalable
+	if err := json.Unmarshal(data, &state); err != nil {
+		return profiles, err
+	}
+
+	for name, marshalable := range state.Profiles {
+		if marshalable.Updated.IsZero() || time.Since(marshalable.Updated) > maxAge || len(marshalable.List) == 0 {
+			continue
+		}
+
+		// Nothing is in use yet
+		for i := range marshalable.List {
+			marshalable.List[i].IsCurrent = false
+		}
+
+		canidates := NewCanidatesFromMarshalable(&marshalable)
+		profiles[name] = &canidates
+	}
+
+	return profiles, nil
+}
@@ -1,110 +1,256 @@
 package updater
 
 import (
-	"math"
-	urllib "net/url"
+	"fmt"
+	"log"
+	"strings"
+	"time"
 
 	"gitlab.com/Njinx/instx/config"
 )
 
-// Checks whether or not $latency counts as an outlier
-func isOutlier(avgs float64, latency float64, weight float64) bool {
-	outlierMultipler := config.ParseConfig().Updater.Advanced.OutlierMultiplier
+// A response time and how to get it from Timings
+type timingComponent struct {
+	name   string
+	get    func(timings *Timings) Timing
+	weight float64
+}
 
-	if (latency*weight > avgs*outlierMultipler) || (latency < 0) {
-		return true
-	} else {
-		return false
+func getTimingComponents(weights *config.AdvancedConfig) []timingComponent {
+	return []timingComponent{
+		{"initial", func(t *Timings) Timing { return t.Initial }, weights.InitialRespWeight},
+		{"search", func(t *Timings) Timing { return t.Search }, weights.SearchRespWeight},
+		{"google", func(t *Timings) Timing { return t.Google }, weights.GoogleSearchRespWeight},
+		{"wikipedia", func(t *Timings) Timing { return t.Wikipedia }, weights.WikipediaSearchRespWeight},
 	}
 }
 
-func findCanidates(instances *Instances) Canidates {
-	conf := config.ParseConfig().Updater.Advanced
-	avgs := instances.getTimingAvgs()
+// Pick the instances that meet $profile's criteria and aren't outliers, scored
+// on their published response times. Also returns the URLs of the outliers.
+// Averages and outlier tests only take the instances that meet the criteria
+// into account.
+func filterCanidates(instances *Instances, profile *config.ProfileConfig) (Canidates, []string) {
+	conf := profile.Advanced
 
+	now := time.Now()
 	canidates := NewCanidates()
+	canidates.Stale = instances.stale
+	canidates.Rejected = append(canidates.Rejected, instances.rejected...)
+
+	var passed Instances
 	for _, inst := range instances.instanceList {
-		timings := inst.Timings
-		if isOutlier(avgs.Initial, timings.Initial, conf.InitialRespWeight) {
-			continue
-		}
-		if isOutlier(avgs.Search, timings.Search, conf.SearchRespWeight) {
+		if reason, rejected := checkCriteria(&inst, &profile.Criteria, now); rejected {
+			canidates.Reject(inst.Url, reason)
 			continue
 		}
-		if isOutlier(avgs.Google, timings.Google, conf.GoogleSearchRespWeight) {
-			continue
+		passed.instanceList = append(passed.instanceList, inst)
+	}
+
+	avgs := passed.getTimingAvgs()
+	medians := passed.getTimingMedians()
+	components := getTimingComponents(&conf)
+	outlierTests := make([]outlierTest, len(components))
+	for i, component := range components {
+		outlierTests[i] = newOutlierTest(
+			passed.getTimingSamples(component.get),
+			component.get(&avgs).Value,
+			&conf)
+	}
+	method := strings.ToLower(conf.OutlierMethod)
+
+	var outliers []string
+	for _, inst := range passed.instanceList {
+
+		// Timings that weren't published are assumed to be typical so that
+		// they neither help nor hurt. Static sources don't publish any.
+		timings := inst.Timings.withDefaults(medians)
+
+		isOutlier := false
+		for i, component := range components {
+			latency := component.get(&timings).Value
+			if outlierTests[i](latency, component.weight) {
+				canidates.Reject(inst.Url, fmt.Sprintf(
+					"Outlier (%s): %s response time of %.02fs", method, component.name, latency))
+				isOutlier = true
+				break
+			}
 		}
-		if isOutlier(avgs.Wikipedia, timings.Wikipedia, conf.WikipediaSearchRespWeight) {
+		if isOutlier {
+			outliers = append(outliers, inst.Url)
 			continue
 		}
 
-		score := timings.Initial/conf.InitialRespWeight + timings.Search/conf.SearchRespWeight + timings.Google/conf.GoogleSearchRespWeight + timings.Wikipedia/conf.WikipediaSearchRespWeight
-
-		// Honest to God I have no idea what's happening here
-		score = math.Floor(score*100) / 100
+		score := timings.Initial.Value/conf.InitialRespWeight + timings.Search.Value/conf.SearchRespWeight + timings.Google.Value/conf.GoogleSearchRespWeight + timings.Wikipedia.Value/conf.WikipediaSearchRespWeight
 
 		canidates.PushBack(Canidate{
-			inst,
-			score,
-			false,
+			Instance:     inst,
+			ResponseTime: score,
 		})
 	}
 
-	// Now that we've weeded out the bad instances, lets conduct some actual latency
-	// tests for more accurate results.
-	getUrls := func(x *Canidates) []string {
-		var urls []string
-		x.Iterate(func(canidate *Canidate) bool {
-			urls = append(urls, canidate.Url)
+	return canidates, outliers
+}
+
+// Rank the instances separately for every profile. Each instance is only
+// tested once no matter how many profiles want it.
+func findCanidates(instances *Instances) ProfileCanidates {
+	c := config.ParseConfig()
+
+	ret := make(ProfileCanidates)
+	var urls []string
+	seen := make(map[string]bool)
+	for _, name := range c.ProfileNames() {
+		profile, _ := c.GetProfile(name)
+		canidates, profileOutliers := filterCanidates(instances, &profile)
+		ret[name] = &canidates
+
+		displayName := name
+		if name == config.DEFAULT_PROFILE {
+			displayName = "default"
+		}
+		log.Printf("Profile \"%s\": %d instances rejected (%d outliers), %d left to test\n",
+			displayName, len(canidates.Rejected), len(profileOutliers), canidates.Len())
+
+		canidates.Iterate(func(canidate *Canidate) bool {
+			if !seen[canidate.Url] {
+				seen[canidate.Url] = true
+				urls = append(urls, canidate.Url)
+			}
 			return false
 		})
-		return urls
 	}
 
-	testResults := doLatencyTests(getUrls(&canidates))
-	refineTestCanidates(testResults, &canidates)
+	// Now that we've weeded out the bad instances, lets conduct some actual latency
+	// tests for more accurate results.
+	latencies := runLatencyTests(urls)
 
-	canidates.Sort()
+	// Pings only tell us the host is up, so make sure searching actually works
+	probeConf := c.Updater.SearchProbe
+	var probes map[string]SearchProbeResult
+	if probeConf.Enabled {
+		probes = doSearchProbes(urls, probeConf.Query, time.Duration(probeConf.Timeout)*time.Second)
+	}
+
+	now := time.Now()
+	historyEnabled := c.Updater.History.Enabled
+	if historyEnabled {
+		recordHistory(urls, latencies, probes, now)
+	}
+
+	for name, canidates := range ret {
+		profile, _ := c.GetProfile(name)
+
+		refineTestCanidates(latencies, canidates, &profile.Advanced)
+		if probeConf.Enabled {
+			applySearchProbes(canidates, probes)
+		}
+		if historyEnabled {
+			applyReliability(canidates, now)
+		}
+		scoreCanidates(canidates, &profile.Advanced, now)
+		canidates.Updated = now
+	}
 
-	// // For use as a stack the best canidates need to be on top
-	// canidates.Reverse()
+	// Ranks are tracked for the default profile
+	if historyEnabled {
+		recordRanks(ret[config.DEFAULT_PROFILE], now)
+	}
 
-	return canidates
+	return ret
 }
 
-// Since our data from searx.space might be old, we should conduct
-// real-time tests.
-func refineTestCanidates(testResults []LatencyResponse, canidates *Canidates) {
+// Score and sort $canidates, taking the penalties at $now into account.
+// Canidates that failed the search probe go last if
+// `updater.search_probe.on_failure` is "demote".
+func scoreCanidates(canidates *Canidates, advanced *config.AdvancedConfig, now time.Time) {
+	applyPenalties(canidates, now)
 
-	// TODO: Refactor latency test functions so this isn't needed
-	// url -> Canidate{}
-	resultToCanidate := func(result LatencyResponse, canidates *Canidates) *Canidate {
-		var ret *Canidate
-		canidates.Iterate(func(canidate *Canidate) bool {
-			if parsed, _ := urllib.Parse(canidate.Url); parsed.Host == result.hostname {
-				ret = canidate
-				return true
-			}
+	getScorer(advanced).Score(canidates)
+	canidates.Sort()
 
-			return false
+	probeConf := config.ParseConfig().Updater.SearchProbe
+	if probeConf.Enabled && strings.ToLower(probeConf.OnFailure) == "demote" {
+		canidates.MoveToBackIf(func(canidate *Canidate) bool {
+			return canidate.SearchProbe != nil && !canidate.SearchProbe.Ok
 		})
+	}
+}
+
+// Combine the score from searx.space's timings with our own latency test.
+// Instances that never responded are penalized rather than dropped since
+// plenty of networks block pings.
+func blendScore(publishedScore float64, result *LatencyResponse, weights *config.AdvancedConfig) float64 {
+	score := publishedScore * weights.SearxSpaceWeight
+	if result.IsAlive {
+		score += result.AvgLatency * weights.RttWeight
+		score += result.PacketLoss / 100 * weights.PacketLossWeight
+	} else {
+		score += weights.ProbeFailurePenalty
+	}
+
+	return score
+}
 
-		return ret
+// Since our data from searx.space might be old, we should conduct
+// real-time tests. Returns url -> LatencyResponse.
+func runLatencyTests(urls []string) map[string]LatencyResponse {
+	results := make(map[string]LatencyResponse)
+	var deadUrls []string
+	for _, result := range doLatencyTests(urls) {
+		results[result.Url] = result
+		if !result.IsAlive {
+			deadUrls = append(deadUrls, result.Url)
+		}
+	}
+
+	// If our URL isn't responding, do a more intensive latency test
+	for _, result := range doLatencyTestsIntensive(deadUrls) {
+		results[result.Url] = result
 	}
 
-	newCanidates := NewCanidates()
-	for _, result := range testResults {
+	return results
+}
 
-		// If our URL isn't responding, do a more intensive latency test
-		if !result.isAlive {
-			intensiveResult := doLatencyTestIntensive(result.hostname)
-			result.isAlive = intensiveResult.isAlive
+// Blend the latency test $results into each canidate's response time using
+// $weights
+func refineTestCanidates(results map[string]LatencyResponse, canidates *Canidates, weights *config.AdvancedConfig) {
+	for elem := canidates.Front(); elem != nil; elem = elem.Next() {
+		canidate, ok := elem.Value.(Canidate)
+		if !ok {
+			continue
 		}
 
-		if result.isAlive {
-			newCanidates.PushBack(resultToCanidate(result, canidates))
+		result, ok := results[canidate.Url]
+		if !ok {
+			continue
 		}
+
+		canidate.Latency = &result
+		canidate.ResponseTime = blendScore(canidate.ResponseTime, &result, weights)
+		elem.Value = canidate
 	}
+}
+
+// Copy the search probe $results into the canidates. Canidates that failed
+// are removed if `updater.search_probe.on_failure` is "drop".
+func applySearchProbes(canidates *Canidates, results map[string]SearchProbeResult) {
+	conf := config.ParseConfig().Updater.SearchProbe
+	drop := strings.ToLower(conf.OnFailure) == "drop"
+
+	for elem := canidates.Front(); elem != nil; {
+		next := elem.Next()
+
+		if canidate, ok := elem.Value.(Canidate); ok {
+			if result, ok := results[canidate.Url]; ok {
+				canidate.SearchProbe = &result
+				elem.Value = canidate
 
-	canidates = &newCanidates
+				if drop && !result.Ok {
+					canidates.Remove(elem)
+				}
+			}
+		}
+
+		elem = next
+	}
 }
@@ -0,0 +1,85 @@
+package updater
+
+import (
+	"fmt"
+	"testing"
+
+	"gitlab.com/Njinx/instx/config"
+	"gopkg.in/yaml.v3"
+)
+
+func TestFilterCanidatesIgnoresRejected(t *testing.T) {
+	profile := config.ProfileConfig{}
+	profile.Criteria = config.CriteriaConfig{
+		MinimumTlsGrade:   "A",
+		AllowedHttpGrades: []string{"V"},
+		SearxngPreference: "impartial",
+	}
+	profile.Advanced = config.AdvancedConfig{
+		InitialRespWeight:         1,
+		SearchRespWeight:          1,
+		GoogleSearchRespWeight:    1,
+		WikipediaSearchRespWeight: 1,
+		OutlierMethod:             "mean",
+		OutlierMultiplier:         2,
+	}
+
+	newInstance := func(url string, tlsGrade string, search float64) Instance {
+		return Instance{
+			Url:       url,
+			TlsGrade:  tlsGrade,
+			HttpGrade: "v",
+			Timings:   Timings{Search: Timing{search, true}},
+		}
+	}
+
+	var instances Instances
+	for i, search := range []float64{1.0, 1.1, 1.2, 2.0} {
+		instances.instanceList = append(instances.instanceList,
+			newInstance(fmt.Sprintf("https://good%d.example/", i), "A", search))
+	}
+
+	// Fast instances that fail the criteria would otherwise pull the
+	// average down far enough to make 2s an outlier
+	for i := 0; i < 10; i++ {
+		instances.instanceList = append(instances.instanceList,
+			newInstance(fmt.Sprintf("https://bad%d.example/", i), "F", 0.5))
+	}
+
+	canidates, outliers := filterCanidates(&instances, &profile)
+	if canidates.Len() != 4 {
+		t.Errorf("got %d canidates, want 4", canidates.Len())
+	}
+	if len(outliers) != 0 {
+		t.Errorf("unexpected outliers: %v", outliers)
+	}
+	if len(canidates.Rejected) != 10 {
+		t.Errorf("got %d rejections, want 10", len(canidates.Rejected))
+	}
+}
+
+func TestBlendScoreDefaultsLetRttReorder(t *testing.T) {
+	var conf config.Config
+	data, err := config.DEFAULT_CONFIG_FS.ReadFile(config.DEFAULT_CONFIG_FILE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		t.Fatal(err)
+	}
+	weights := &conf.Updater.Advanced
+
+	// Slightly faster according to searx.space, but on the other side of
+	// the world
+	far := blendScore(2.0, &LatencyResponse{AvgLatency: 0.3, IsAlive: true}, weights)
+	near := blendScore(2.5, &LatencyResponse{AvgLatency: 0.02, IsAlive: true}, weights)
+	if near >= far {
+		t.Errorf("expected the nearby instance to win, got %f for it and %f for the distant one", near, far)
+	}
+
+	// Losing half the probes outweighs a better published score
+	lossy := blendScore(2.0, &LatencyResponse{AvgLatency: 0.02, IsAlive: true, PacketLoss: 50}, weights)
+	if lossy <= near {
+		t.Errorf("expected the lossy instance to lose, got %f for it and %f for the reliable one", lossy, near)
+	}
+}
@@ -0,0 +1,130 @@
+package updater
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fastjson"
+)
+
+// A single response time in seconds. Ok is false if it wasn't published.
+type Timing struct {
+	Value float64 `json:"value"`
+	Ok    bool    `json:"ok"`
+}
+
+func (s *Timing) String() string {
+	if s.Ok {
+		return fmt.Sprintf("%.02f", s.Value)
+	} else {
+		return "N/A"
+	}
+}
+
+// Instance response times as specified here: https://searx.space#help-responsetime.
+// "Timings" is synonymous with "Latency" in this project. Not sure why
+// I picked the former.
+type Timings struct {
+	Initial   Timing `json:"initial"`
+	Search    Timing `json:"search"`
+	Google    Timing `json:"google"`
+	Wikipedia Timing `json:"wikipedia"`
+}
+
+func (s *Timings) String() string {
+	return fmt.Sprintf("( I=%s, S=%s, G=%s, W=%s )",
+		s.Initial.String(),
+		s.Search.String(),
+		s.Google.String(),
+		s.Wikipedia.String())
+}
+
+// Fill in the timings that weren't published with $defaults
+func (s Timings) withDefaults(defaults Timings) Timings {
+	pick := func(timing Timing, def Timing) Timing {
+		if timing.Ok {
+			return timing
+		}
+		return def
+	}
+
+	return Timings{
+		Initial:   pick(s.Initial, defaults.Initial),
+		Search:    pick(s.Search, defaults.Search),
+		Google:    pick(s.Google, defaults.Google),
+		Wikipedia: pick(s.Wikipedia, defaults.Wikipedia),
+	}
+}
+
+// Parse a percentile statistic ("p90" -> 0.90)
+func parsePercentile(stat string) (float64, bool) {
+	if !strings.HasPrefix(stat, "p") {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(stat[1:])
+	if err != nil || n <= 0 || n >= 100 {
+		return 0, false
+	}
+	return float64(n) / 100, true
+}
+
+// Read one of the timing blocks searx.space publishes for an instance:
+//   - initial: loading the front page
+//   - search: a search across all engines
+//   - search_go: a search using only Google
+//   - search_wp: a search using only Wikipedia
+//
+// $stat picks the statistic (value, median, mean, stdev or pNN). The initial
+// timing only has a value, so when $stat isn't published the next best
+// central statistic is used. Percentiles that aren't published are estimated
+// from the median and standard deviation.
+func readTiming(v *fastjson.Value, block string, stat string) Timing {
+	all := v.Get("timing", block, "all")
+	if all == nil || all.Type() != fastjson.TypeObject {
+		return Timing{}
+	}
+
+	get := func(key string) (float64, bool) {
+		val := all.Get(key)
+		if val == nil || val.Type() != fastjson.TypeNumber {
+			return 0, false
+		}
+
+		n, err := val.Float64()
+		if err != nil || n <= 0 {
+			return 0, false
+		}
+		return n, true
+	}
+
+	stat = strings.ToLower(stat)
+	if n, ok := get(stat); ok {
+		return Timing{n, true}
+	}
+
+	if p, ok := parsePercentile(stat); ok {
+		median, okMedian := get("median")
+		stdev, okStdev := get("stdev")
+		if okMedian && okStdev {
+			z := math.Sqrt2 * math.Erfinv(2*p-1)
+			return Timing{math.Max(median+z*stdev, 0), true}
+		}
+	}
+
+	// The standard deviation on its own isn't a response time, so don't
+	// substitute one for it
+	if stat == "stdev" {
+		return Timing{}
+	}
+
+	for _, fallback := range []string{"median", "value", "mean"} {
+		if n, ok := get(fallback); ok {
+			return Timing{n, true}
+		}
+	}
+
+	return Timing{}
+}
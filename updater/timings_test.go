@@ -0,0 +1,68 @@
+package updater
+
+import (
+	"math"
+	"testing"
+
+	"github.com/valyala/fastjson"
+)
+
+const instanceTimingJson = `{
+	"timing": {
+		"initial": {"success_percentage": 100.0, "all": {"value": 0.25}},
+		"search": {"success_percentage": 100.0, "all": {"median": 0.8, "stdev": 0.2, "mean": 0.9}},
+		"search_go": {"success_percentage": 100.0, "all": {"median": 1.5, "stdev": 0.5}},
+		"search_wp": {"success_percentage": 0.0, "error": "Timeout"}
+	}
+}`
+
+func TestReadTiming(t *testing.T) {
+	v := fastjson.MustParse(instanceTimingJson)
+
+	tests := []struct {
+		block string
+		stat  string
+		want  Timing
+	}{
+		{"initial", "value", Timing{0.25, true}},
+		{"initial", "median", Timing{0.25, true}},
+		{"search", "median", Timing{0.8, true}},
+		{"search", "mean", Timing{0.9, true}},
+		{"search", "stdev", Timing{0.2, true}},
+		{"search", "p50", Timing{0.8, true}},
+		{"search_go", "median", Timing{1.5, true}},
+		{"search_go", "mean", Timing{1.5, true}},
+		{"initial", "stdev", Timing{}},
+		{"search_wp", "median", Timing{}},
+		{"missing", "median", Timing{}},
+	}
+
+	for _, test := range tests {
+		got := readTiming(v, test.block, test.stat)
+		if got.Ok != test.want.Ok || math.Abs(got.Value-test.want.Value) > 1e-9 {
+			t.Errorf("readTiming(%s, %s) = %+v, want %+v", test.block, test.stat, got, test.want)
+		}
+	}
+
+	// The 90th percentile is 1.2816 standard deviations above the median
+	p90 := readTiming(v, "search", "p90")
+	if !p90.Ok || math.Abs(p90.Value-(0.8+1.2816*0.2)) > 1e-3 {
+		t.Errorf("readTiming(search, p90) = %+v", p90)
+	}
+}
+
+func TestGetTimingMedians(t *testing.T) {
+	var instances Instances
+	for _, search := range []float64{0.8, 1.0, 30.0} {
+		instances.instanceList = append(instances.instanceList, Instance{
+			Timings: Timings{Search: Timing{search, true}},
+		})
+	}
+
+	if got := instances.getTimingMedians().Search; got != (Timing{1.0, true}) {
+		t.Errorf("median search time = %+v, want 1s", got)
+	}
+	if got := instances.getTimingMedians().Initial; got.Ok {
+		t.Errorf("median initial time = %+v, want none", got)
+	}
+}
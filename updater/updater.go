@@ -1,20 +1,58 @@
 package updater
 
 import (
+	"log"
 	"sync"
 	"time"
 
 	"gitlab.com/Njinx/instx/config"
 )
 
-// Update the instances list
-func updateBestServers(updatedCanidates *Canidates, updatedCanidatesMutex *sync.Mutex) {
-	instances := NewInstances("https://searx.space/data/instances.json")
-	canidates := findCanidates(&instances)
+// How long to wait before trying again when an update fails
+const UPDATE_RETRY_INTERVAL = 5 * time.Minute
+
+// Update the instances list. On failure the current list is left untouched.
+func updateBestServers(updatedCanidates ProfileCanidates, updatedCanidatesMutex *sync.Mutex) error {
+	instances, err := NewInstances(getSources())
+	if err != nil {
+		return err
+	}
+	profiles := findCanidates(&instances)
 
 	updatedCanidatesMutex.Lock()
-	*updatedCanidates = canidates
+	for name, canidates := range profiles {
+		*updatedCanidates[name] = *canidates
+	}
 	updatedCanidatesMutex.Unlock()
+
+	if config.ParseConfig().Updater.StateMaxAge > 0 {
+		if err := saveState(profiles); err != nil {
+			log.Printf("Could not save state: %s\n", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Get the canidates for profile $name to use until the first update
+// finishes. That's the previous ranking if it's recent enough, otherwise just
+// the default instance.
+func getInitialCanidates(name string, saved ProfileCanidates) Canidates {
+	if canidates, ok := saved[name]; ok {
+		return *canidates
+	}
+
+	// Since the updater hasn't actually run yet, give the proxy the default
+	// instance (as a dummy Canidates object)
+	canidates := NewCanidates()
+	canidates.PushFront(Canidate{
+		Instance: Instance{
+			Url: config.ParseConfig().DefaultInstance,
+		},
+		Score:     0.0,
+		IsCurrent: true,
+	})
+	return canidates
 }
 
 type ErrUpdateInProgress struct{}
@@ -37,34 +75,47 @@ func ForceUpdate() error {
 }
 
 // Start the updater loop
-func Run(updatedCanidates *Canidates, updatedCanidatesMutex *sync.Mutex) {
+func Run(updatedCanidates ProfileCanidates, updatedCanidatesMutex *sync.Mutex) {
 
 	forceUpdateChan = make(chan bool)
 	updateInProgress = false
 
-	// Since the updater hasn't actually run yet, give the proxy the default
-	// instance (as a dummy Canidates object)
+	var saved ProfileCanidates
+	if maxAge := config.ParseConfig().Updater.StateMaxAge; maxAge > 0 {
+		var err error
+		saved, err = loadState(time.Duration(maxAge) * time.Minute)
+		if err != nil {
+			log.Printf("Could not load state: %s\n", err.Error())
+		}
+	}
+
+	// The full update can take a while, so start with what we've got
 	updatedCanidatesMutex.Lock()
-	updatedCanidates.PushFront(Canidate{
-		Instance{
-			Url: config.ParseConfig().DefaultInstance,
-		},
-		0.0,
-		true,
-	})
+	for name, canidates := range updatedCanidates {
+		*canidates = getInitialCanidates(name, saved)
+	}
 	updatedCanidatesMutex.Unlock()
 
-	updateInterval := time.Duration(config.ParseConfig().Updater.UpdateInterval)
+	updateInterval := time.Duration(config.ParseConfig().Updater.UpdateInterval) * time.Minute
 	for {
 		updateInProgress = true
-		updateBestServers(updatedCanidates, updatedCanidatesMutex)
+		err := updateBestServers(updatedCanidates, updatedCanidatesMutex)
 		updateInProgress = false
 
-		// Wait $updateInterval minutes or until an update is forced
+		// The network might not be up yet, so try again sooner than usual
+		wait := updateInterval
+		if err != nil {
+			log.Printf("Could not update the list of instances: %s\n", err.Error())
+			if UPDATE_RETRY_INTERVAL < wait {
+				wait = UPDATE_RETRY_INTERVAL
+			}
+		}
+
+		// Wait $wait or until an update is forced
 		select {
 		case <-forceUpdateChan:
 			forceUpdateChan <- false
-		case <-time.After(updateInterval * time.Minute):
+		case <-time.After(wait):
 		}
 	}
 }
@@ -0,0 +1,69 @@
+package updater
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearXNG versions start with their release date, e.g. "2023.10.22+526d5c7b3"
+var versionDateRegexp = regexp.MustCompile(`^(\d{4})\.(\d{1,2})\.(\d{1,2})(?:\D|$)`)
+
+// Get the release date of a SearXNG version. SearX uses semantic versioning,
+// so there's nothing to go on for those.
+func parseVersionDate(version string) (time.Time, bool) {
+	match := versionDateRegexp.FindStringSubmatch(version)
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	year, _ := strconv.Atoi(match[1])
+	month, _ := strconv.Atoi(match[2])
+	day, _ := strconv.Atoi(match[3])
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}, false
+	}
+
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC), true
+}
+
+// SearX versions, e.g. "1.1.0-69-75b859d2"
+var semverRegexp = regexp.MustCompile(`^(\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+
+// Compare two SearX versions. Returns -1, 0 or 1 like strings.Compare, and
+// false if either can't be parsed.
+func compareSemver(a string, b string) (int, bool) {
+	matchA := semverRegexp.FindStringSubmatch(a)
+	matchB := semverRegexp.FindStringSubmatch(b)
+	if matchA == nil || matchB == nil {
+		return 0, false
+	}
+
+	for i := 1; i <= 3; i++ {
+		x, _ := strconv.Atoi(matchA[i])
+		y, _ := strconv.Atoi(matchB[i])
+		if x < y {
+			return -1, true
+		} else if x > y {
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+// Whether $version is from a SearXNG build with local changes
+func isDirtyVersion(version string) bool {
+	return strings.Contains(strings.ToLower(version), "dirty")
+}
+
+// Get a git URL in a form that can be compared, e.g.
+// "github.com/searxng/searxng" for "https://github.com/searxng/searxng.git"
+func normalizeGitUrl(url string) string {
+	url = strings.ToLower(strings.TrimSpace(url))
+	if _, rest, ok := strings.Cut(url, "://"); ok {
+		url = rest
+	}
+	url = strings.TrimSuffix(url, "/")
+	return strings.TrimSuffix(url, ".git")
+}
@@ -0,0 +1,21 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Get the directory instx keeps cached data and state in, creating it
+// if necessary.
+func GetCacheDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(cacheDir, "instx")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}